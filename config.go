@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io"
+	"time"
 )
 
 // Config for polymerase
@@ -12,27 +13,90 @@ type Config struct {
 	VaultAppID       string
 	VaultUserIDPath  string
 	VaultFactoryFunc func(Config) (Vault, error)
+	ConsulAddr       string
+	ConsulToken      string
+	ConsulFactory    func(Config) (KV, error)
+	EtcdAddrs        []string
+	EtcdCert         string
+	EtcdKey          string
+	EtcdCA           string
+	EtcdFactory      func(Config) (KV, error)
+	Providers        []string
+	VaultFixtures    string
+	SecretsDir       string
+	FileMaxMode      string
+	FileTrimNewline  bool
+	Templates        []string
+	Manifest         string
+	Prune            bool
+	Includes         []string
+	LeftDelim        string
+	RightDelim       string
+	Strict           bool
+	DataFiles        []string
+	EnvFiles         []string
+	CheckEnv         bool
+	DepsFormat       string
+	DepsPolicy       bool
+	DepsUploadPolicy string
+	DepsAllowDynamic bool
+	SkipCapabilities bool
+	OutputFile       string
+	Diff             bool
+	ShowSecrets      bool
+	Debounce         time.Duration
+	Refresh          time.Duration
+	ExecOnChange     []string
+	SignalOnChange   []string
+	HookTimeout      time.Duration
+	HookFailure      string
+	EnvTemplate      string
+	Watch            bool
+	OnChange         string
+	RevokeLeases     bool
 	Input            io.Reader
 	Output           io.Writer
 }
 
 // Validate the config
 func (c Config) Validate() (bool, error) {
-	if len(c.VaultAddr) == 0 {
-		return false, fmt.Errorf("Invalid vault address")
-	}
+	if !c.Offline() {
+		if len(c.VaultAddr) == 0 {
+			return false, fmt.Errorf("Invalid vault address")
+		}
 
-	if len(c.VaultToken) > 0 && (len(c.VaultAppID) > 0 || len(c.VaultUserIDPath) > 0) {
-		return false, fmt.Errorf("Conflicting vault authentication strategies. Both app_id and token auth specified")
-	}
+		if len(c.VaultToken) > 0 && (len(c.VaultAppID) > 0 || len(c.VaultUserIDPath) > 0) {
+			return false, fmt.Errorf("Conflicting vault authentication strategies. Both app_id and token auth specified")
+		}
+
+		if len(c.VaultToken) == 0 && len(c.VaultAppID) == 0 && len(c.VaultUserIDPath) == 0 {
+			return false, fmt.Errorf("No vault authentication strategy provided. Please specify a vault token or app ID and user ID path")
+		}
 
-	if len(c.VaultToken) == 0 && len(c.VaultAppID) == 0 && len(c.VaultUserIDPath) == 0 {
-		return false, fmt.Errorf("No vault authentication strategy provided. Please specify a vault token or app ID and user ID path")
+		if (len(c.VaultAppID) > 0 && len(c.VaultUserIDPath) == 0) || (len(c.VaultAppID) == 0 && len(c.VaultUserIDPath) > 0) {
+			return false, fmt.Errorf("Invalid vault authentication strategy provided. Please specify an app ID AND user ID path")
+		}
 	}
 
-	if (len(c.VaultAppID) > 0 && len(c.VaultUserIDPath) == 0) || (len(c.VaultAppID) == 0 && len(c.VaultUserIDPath) > 0) {
-		return false, fmt.Errorf("Invalid vault authentication strategy provided. Please specify an app ID AND user ID path")
+	if err := c.validateDelims(); err != nil {
+		return false, err
 	}
 
 	return true, nil
 }
+
+// validateDelims checks the template delimiters are either both set or both unset
+func (c Config) validateDelims() error {
+	if (len(c.LeftDelim) > 0) != (len(c.RightDelim) > 0) {
+		return fmt.Errorf("Invalid template delimiters. Please specify both a left AND right delimiter")
+	}
+
+	return nil
+}
+
+// Offline reports whether vault is never contacted, because secrets come from
+// --vault-fixtures or --provider serves vault:// URIs with another provider
+func (c Config) Offline() bool {
+	impl, _ := providerImpl(c.Providers, "vault")
+	return len(c.VaultFixtures) > 0 || impl != "vault"
+}
@@ -10,6 +10,8 @@ func TestValidateConfig(t *testing.T) {
 	invalidWithNoAddr := Config{VaultAppID: "SomeID", VaultUserIDPath: "some/path"}
 	invalidWithOnlyAppID := Config{VaultAddr: "google.com", VaultAppID: "SomeID"}
 	invalidWithOnlyUserIDPath := Config{VaultAddr: "google.com", VaultUserIDPath: "some/path"}
+	validWithDelims := Config{VaultAddr: "google.com", VaultToken: "SomeToken", LeftDelim: "[[", RightDelim: "]]"}
+	invalidWithOnlyLeftDelim := Config{VaultAddr: "google.com", VaultToken: "SomeToken", LeftDelim: "[["}
 
 	if valid, _ := validWithToken.Validate(); valid != true {
 		t.Fatalf("Config %v was invalid but should have been valid", config)
@@ -36,4 +38,10 @@ func TestValidateConfig(t *testing.T) {
 	if valid, _ := invalidWithOnlyUserIDPath.Validate(); valid != false {
 		t.Fatalf("Config %v was valid but should have been invalid", config)
 	}
+	if valid, _ := validWithDelims.Validate(); valid != true {
+		t.Fatalf("Config %v was invalid but should have been valid", config)
+	}
+	if valid, _ := invalidWithOnlyLeftDelim.Validate(); valid != false {
+		t.Fatalf("Config %v was valid but should have been invalid", config)
+	}
 }
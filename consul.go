@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/armon/consul-api"
+)
+
+// ConsulKV reads values from the consul KV store
+type ConsulKV struct {
+	kv *consulapi.KV
+}
+
+// NewConsulKV creates a consul KV client using the given config. The address
+// may include a scheme and defaults to the local agent.
+func NewConsulKV(config Config) (KV, error) {
+	cc := &consulapi.Config{Address: config.ConsulAddr, Token: config.ConsulToken}
+	if i := strings.Index(cc.Address, "://"); i >= 0 {
+		cc.Scheme, cc.Address = cc.Address[:i], cc.Address[i+3:]
+	}
+
+	client, err := consulapi.NewClient(cc)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConsulKV{kv: client.KV()}, nil
+}
+
+// Get returns the value of key, failing if it doesn't exist
+func (c ConsulKV) Get(key string) (string, error) {
+	pair, _, err := c.kv.Get(strings.TrimPrefix(key, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("Error reading %v from consul: %v", key, err)
+	}
+	if pair == nil {
+		return "", fmt.Errorf("Consul key %v not found", key)
+	}
+
+	return string(pair.Value), nil
+}
+
+// Tree returns every key under prefix with prefix removed. Folder entries are skipped.
+func (c ConsulKV) Tree(prefix string) (map[string]string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	pairs, _, err := c.kv.List(prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing %v from consul: %v", prefix, err)
+	}
+
+	tree := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(strings.TrimPrefix(pair.Key, prefix), "/")
+		if len(key) == 0 || strings.HasSuffix(key, "/") {
+			continue
+		}
+		tree[key] = string(pair.Value)
+	}
+
+	return tree, nil
+}
+
+func consulKV() (KV, error) {
+	factory := config.ConsulFactory
+	if factory == nil {
+		factory = NewConsulKV
+	}
+
+	return openKV(&consul, "consul", factory)
+}
+
+func consulGetString(key string) (string, error) {
+	return kvGetString(consulKV, key)
+}
+
+func consulGetTree(prefix string) (map[string]string, error) {
+	return kvGetTree(consulKV, prefix)
+}
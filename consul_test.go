@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newTestConsul serves the consul KV read API from values
+func newTestConsul(t *testing.T, token string, values map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		_, recurse := r.URL.Query()["recurse"]
+
+		var keys []string
+		for k := range values {
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Strings(keys)
+
+		var pairs []map[string]interface{}
+		for _, k := range keys {
+			pairs = append(pairs, map[string]interface{}{"Key": k, "Value": []byte(values[k])})
+		}
+		if err := json.NewEncoder(w).Encode(pairs); err != nil {
+			t.Fatal(err)
+		}
+	}))
+}
+
+func TestConsulKV(t *testing.T) {
+	server := newTestConsul(t, "TOKEN", map[string]string{
+		"service/app/feature_flags":   "beta,dark-mode",
+		"service/app/db/":             "",
+		"service/app/db/host":         "db.internal",
+		"service/app/db/port":         "5432",
+		"service/other/feature_flags": "none",
+	})
+	defer server.Close()
+
+	kv, err := NewConsulKV(Config{ConsulAddr: server.URL, ConsulToken: "TOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := kv.Get("/service/app/feature_flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "beta,dark-mode" {
+		t.Fatalf("Expected beta,dark-mode but got %v", val)
+	}
+
+	if _, err := kv.Get("service/app/missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("Expected a missing key to fail but got %v", err)
+	}
+
+	tree, err := kv.Tree("service/app/db/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != 2 || tree["host"] != "db.internal" || tree["port"] != "5432" {
+		t.Fatalf("Unexpected tree %v", tree)
+	}
+
+	denied, err := NewConsulKV(Config{ConsulAddr: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := denied.Get("service/app/feature_flags"); err == nil {
+		t.Fatalf("Expected a request without the token to fail")
+	}
+}
+
+func TestConsulFunctions(t *testing.T) {
+	server := newTestConsul(t, "", map[string]string{
+		"service/app/feature_flags": "beta",
+		"service/app/db/host":       "db.internal",
+		"service/app/db/port":       "5432",
+	})
+	defer server.Close()
+
+	context := newTestContext("s3cr3t", "", &bytes.Buffer{})
+	setupTest(context)
+	config.ConsulAddr = server.URL
+	configureVault()
+	os.Setenv("POLYMERASE_TEST_CONSUL", "env")
+
+	tmpl, err := TemplateFromString(`{{ env "POLYMERASE_TEST_CONSUL" }} {{ vault "secret/db" }} {{ consul "service/app/feature_flags" }}{{ range $k, $v := consulTree "service/app/db" }} {{ $k }}={{ $v }}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "env s3cr3t beta host=db.internal port=5432", t)
+
+	tmpl, err = TemplateFromString(`{{ consul "service/app/missing" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, nil); err == nil || !strings.Contains(err.Error(), "service/app/missing not found") {
+		t.Fatalf("Expected a missing key to fail but got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Keys of the template context that hold structured values rather than a
+// single environment variable. They take precedence over environment
+// variables of the same name, which remain available through .Env.
+const (
+	contextEnv   = "Env"
+	contextData  = "Data"
+	contextVault = "Vault"
+	contextMeta  = "Meta"
+)
+
+// VaultInfo describes the vault server a template is rendered against
+type VaultInfo struct {
+	Addr       string
+	AuthMethod string
+}
+
+// MetaInfo describes the environment a template is rendered in
+type MetaInfo struct {
+	Hostname string
+	Time     time.Time
+}
+
+// templateContext returns the data templates are executed with:
+//
+//	.Env    every environment variable, including --env-file variables
+//	.Data   the merged --data files
+//	.Vault  the vault server and auth method in use
+//	.Meta   the hostname and time of the render
+//
+// For backwards compatibility every environment variable is also a top-level key, e.g. .HOME
+func templateContext() (map[string]interface{}, error) {
+	data, err := LoadDataFiles(config.DataFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	authMethod := "token"
+	if len(config.VaultAppID) > 0 {
+		authMethod = "app-id"
+	}
+
+	environment, err := loadEnv()
+	if err != nil {
+		return nil, err
+	}
+	ctx := make(map[string]interface{}, len(environment)+4)
+	for key, val := range environment {
+		ctx[key] = val
+	}
+	ctx[contextEnv] = environment
+	ctx[contextData] = data
+	ctx[contextVault] = VaultInfo{Addr: config.VaultAddr, AuthMethod: authMethod}
+	ctx[contextMeta] = MetaInfo{Hostname: hostname, Time: time.Now()}
+
+	return ctx, nil
+}
+
+// isContextKey reports whether key is one of the structured context keys
+func isContextKey(key string) bool {
+	switch key {
+	case contextEnv, contextData, contextVault, contextMeta:
+		return true
+	}
+
+	return false
+}
+
+// contextEnvironment returns the environment held by template data, which is
+// either a full template context or a plain map of environment variables
+func contextEnvironment(data interface{}) (map[string]string, bool) {
+	switch data := data.(type) {
+	case map[string]interface{}:
+		environment, ok := data[contextEnv].(map[string]string)
+		return environment, ok
+	case map[string]string:
+		return data, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+// LoadDataFiles reads structured data files and deep-merges them in order, so
+// values from later files win. The format is chosen by file extension.
+func LoadDataFiles(filenames []string) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	for _, filename := range filenames {
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := decodeDataFile(filename, string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filename, err)
+		}
+
+		m, ok := decoded.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v: expected a map at the top level but got %T", filename, decoded)
+		}
+		data = mergeData(data, m)
+	}
+
+	return data, nil
+}
+
+func decodeDataFile(filename string, contents string) (interface{}, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return fromYAML(contents)
+	case ".json":
+		return fromJSON(contents)
+	case ".toml":
+		return fromTOML(contents)
+	case ".hcl":
+		return fromHCL(contents)
+	}
+
+	return nil, fmt.Errorf("Unknown data file format. Expected .yaml, .yml, .json, .toml or .hcl")
+}
+
+// mergeData deep-merges src into dst. Nested maps are merged and any other value in src replaces the one in dst.
+func mergeData(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	for key, srcVal := range src {
+		srcMap, srcIsMap := srcVal.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			dst[key] = mergeData(dstMap, srcMap)
+		} else {
+			dst[key] = srcVal
+		}
+	}
+
+	return dst
+}
+
+func fromYAML(str string) (interface{}, error) {
+	var out interface{}
+	if err := yaml.Unmarshal([]byte(str), &out); err != nil {
+		return nil, err
+	}
+
+	return normalizeData(out), nil
+}
+
+func fromJSON(str string) (interface{}, error) {
+	var out interface{}
+	err := json.Unmarshal([]byte(str), &out)
+	return out, err
+}
+
+func fromTOML(str string) (interface{}, error) {
+	tree, err := toml.Load(str)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeData(tree.ToMap()), nil
+}
+
+func fromHCL(str string) (interface{}, error) {
+	var out map[string]interface{}
+	if err := hcl.Unmarshal([]byte(str), &out); err != nil {
+		return nil, err
+	}
+
+	return normalizeData(out), nil
+}
+
+// normalizeData converts the map[interface{}]interface{} values produced by
+// yaml into map[string]interface{} so every format can be merged and indexed alike.
+// HCL blocks decode as lists of maps, so a block that appears once becomes a plain map.
+func normalizeData(val interface{}) interface{} {
+	switch val := val.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			m[fmt.Sprint(k)] = normalizeData(v)
+		}
+		return m
+	case map[string]interface{}:
+		for k, v := range val {
+			val[k] = normalizeData(v)
+		}
+		return val
+	case []map[string]interface{}:
+		if len(val) == 1 {
+			return normalizeData(val[0])
+		}
+		list := make([]interface{}, len(val))
+		for i, v := range val {
+			list[i] = normalizeData(v)
+		}
+		return list
+	case []interface{}:
+		for i, v := range val {
+			val[i] = normalizeData(v)
+		}
+		return val
+	}
+
+	return val
+}
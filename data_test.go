@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLoadDataFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := writeTestFile(t, dir, "base.yaml", "database:\n  host: db.internal\n  port: 5432\nreplicas: [a, b]\n")
+	env := writeTestFile(t, dir, "production.json", `{"database": {"host": "db.prod"}, "replicas": ["c"]}`)
+	flags := writeTestFile(t, dir, "flags.toml", "[features]\nbeta = true\n")
+	service := writeTestFile(t, dir, "service.hcl", "service {\n  name = \"app\"\n}\n")
+
+	data, err := LoadDataFiles([]string{base, env, flags, service})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	tmpl, err := TemplateFromString("{{ .Data.database.host }}:{{ .Data.database.port }} {{ .Data.replicas }} {{ .Data.features.beta }} {{ .Data.service.name }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, map[string]interface{}{"Data": data}); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "db.prod:5432 [c] true app", t)
+
+	unknown := writeTestFile(t, dir, "data.ini", "")
+	if _, err := LoadDataFiles([]string{unknown}); err == nil {
+		t.Fatalf("Expected an error for an unknown data file format")
+	}
+}
+
+func TestFromDataFuncs(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+
+	tmpl, err := TemplateFromString(`{{ (fromYaml .YAML).a.b }} {{ (fromJson .JSON).a }} {{ (fromToml .TOML).a }} {{ (fromHcl .HCL).a }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	data := map[string]string{"YAML": "a:\n  b: yaml", "JSON": `{"a": "json"}`, "TOML": `a = "toml"`, "HCL": `a = "hcl"`}
+	if err := tmpl.Execute(output, data); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "yaml json toml hcl", t)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// vaultValueField is the key of a secret's data that the vault function reads
+const vaultValueField = "value"
+
+var depsCmd = &cobra.Command{
+	Use:   "deps <filename>...",
+	Short: "List the secrets and environment variables templates need",
+	Long:  "Reports the environment variables, vault paths and vault authentication that templates require, or a vault policy granting read access to exactly those paths.",
+	Example: "polymerase deps --format json app.env.tmpl\n" +
+		"polymerase deps --policy app.env.tmpl > app-policy.hcl\n" +
+		"polymerase deps --upload-policy app app.env.tmpl",
+	Run: runDeps,
+}
+
+func init() {
+	depsCmd.Flags().StringVar(&config.DepsFormat, "format", "text", "Output format: text or json.")
+	depsCmd.Flags().BoolVar(&config.DepsPolicy, "policy", false, "Print an HCL vault policy granting read on every referenced path.")
+	depsCmd.Flags().StringVar(&config.DepsUploadPolicy, "upload-policy", "", "Write the generated policy to vault under this name.")
+	depsCmd.Flags().BoolVar(&config.DepsAllowDynamic, "allow-dynamic", false, "Generate a policy even though templates read vault paths it can't include because they are computed.")
+	rootCmd.AddCommand(depsCmd)
+}
+
+// Dependencies are everything a set of templates needs to render
+type Dependencies struct {
+	Templates []string          `json:"templates"`
+	Env       []string          `json:"env"`
+	Vault     []VaultDependency `json:"vault"`
+	Dynamic   []string          `json:"dynamic,omitempty"`
+	Auth      AuthRequirements  `json:"auth"`
+}
+
+// VaultDependency is a vault path and the fields of its secret that are read
+type VaultDependency struct {
+	Path   string   `json:"path"`
+	Fields []string `json:"fields"`
+}
+
+// AuthRequirements describe the vault authentication needed to render
+type AuthRequirements struct {
+	Vault        bool     `json:"vault"`
+	Methods      []string `json:"methods,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+func runDeps(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		return
+	}
+
+	deps, err := TemplateDependencies(args)
+	if err != nil {
+		logger.Fatalf("Error parsing template: %v", err)
+	}
+
+	if len(config.DepsUploadPolicy) > 0 {
+		policy, err := deps.Policy(config.DepsAllowDynamic)
+		if err == nil && len(policy) == 0 {
+			err = fmt.Errorf("The templates reference no vault paths")
+		}
+		if err != nil {
+			logger.Fatalf("Error uploading policy: %v", err)
+		}
+
+		if _, err := config.Validate(); err != nil {
+			logger.Fatalf("Error validating config: %v", err)
+		}
+
+		writer, ok := authenticatedVault().(PolicyWriter)
+		if !ok {
+			logger.Fatalf("Error uploading policy: vault client can't write policies")
+		}
+		if err := writer.PutPolicy(config.DepsUploadPolicy, policy); err != nil {
+			logger.Fatalf("Error uploading policy: %v", err)
+		}
+		logger.Printf("Uploaded policy %v", config.DepsUploadPolicy)
+		return
+	}
+
+	if config.DepsPolicy {
+		policy, err := deps.Policy(config.DepsAllowDynamic)
+		if err != nil {
+			logger.Fatalf("Error generating policy: %v", err)
+		}
+		fmt.Fprint(config.Output, policy)
+		return
+	}
+
+	switch config.DepsFormat {
+	case "json":
+		err = deps.WriteJSON(config.Output)
+	case "text":
+		deps.WriteText(config.Output)
+	default:
+		err = fmt.Errorf("Unknown format %q. Expected text or json", config.DepsFormat)
+	}
+	if err != nil {
+		logger.Fatalf("Error writing dependencies: %v", err)
+	}
+}
+
+// TemplateDependencies parses templates and merges everything they reference
+func TemplateDependencies(filenames []string) (Dependencies, error) {
+	deps := Dependencies{Templates: filenames}
+	envVars := make(map[string]bool)
+	vaultPaths := make(map[string]bool)
+	vaultFields := make(map[string]map[string]bool)
+	dynamic := make(map[string]bool)
+
+	for _, filename := range filenames {
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return Dependencies{}, err
+		}
+
+		tmpl, err := parseTemplate(filename, string(contents), nil)
+		if err != nil {
+			return Dependencies{}, err
+		}
+
+		refs := TemplateReferences(tmpl)
+		for _, key := range refs.EnvVars {
+			envVars[key] = true
+		}
+		for _, path := range refs.VaultPaths {
+			if !vaultPaths[path] {
+				vaultPaths[path] = true
+				vaultFields[path] = make(map[string]bool)
+			}
+			for _, field := range refs.VaultFields[path] {
+				vaultFields[path][field] = true
+			}
+		}
+		for _, name := range refs.Dynamic {
+			dynamic[name] = true
+		}
+	}
+
+	deps.Env = sortedKeys(envVars)
+	deps.Dynamic = sortedKeys(dynamic)
+	deps.Vault = []VaultDependency{}
+	for _, path := range sortedKeys(vaultPaths) {
+		deps.Vault = append(deps.Vault, VaultDependency{Path: path, Fields: sortedKeys(vaultFields[path])})
+	}
+
+	if len(deps.Vault) > 0 || dynamic["vault"] {
+		deps.Auth = AuthRequirements{Vault: true, Methods: []string{"token", "app-id"}, Capabilities: []string{"read"}}
+	}
+
+	return deps, nil
+}
+
+// Policy returns an HCL vault policy granting read on every dependent path.
+// Vault paths computed while the templates run can't be included, so unless
+// allowDynamic is set a policy for templates that compute them, with the
+// vault or secret functions, is an error.
+func (d Dependencies) Policy(allowDynamic bool) (string, error) {
+	if !allowDynamic {
+		for _, name := range d.Dynamic {
+			if name == "vault" || name == "secret" {
+				return "", fmt.Errorf("The templates read vault paths or secret URIs computed while they run, which the policy can't include. Pass --allow-dynamic to generate it anyway")
+			}
+		}
+	}
+
+	var policy []string
+	for _, dep := range d.Vault {
+		policy = append(policy, fmt.Sprintf("path %q {\n  capabilities = [\"read\"]\n}\n", dep.Path))
+	}
+
+	return strings.Join(policy, "\n"), nil
+}
+
+// WriteJSON writes the dependencies as indented JSON
+func (d Dependencies) WriteJSON(w io.Writer) error {
+	out, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// WriteText writes a human readable report of the dependencies
+func (d Dependencies) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Templates: %v\n", strings.Join(d.Templates, ", "))
+	fmt.Fprintf(w, "Environment variables: %v\n", listOrNone(d.Env))
+
+	var paths []string
+	for _, dep := range d.Vault {
+		paths = append(paths, fmt.Sprintf("%v#%v", dep.Path, strings.Join(dep.Fields, ",")))
+	}
+	fmt.Fprintf(w, "Vault paths: %v\n", listOrNone(paths))
+	if len(d.Dynamic) > 0 {
+		fmt.Fprintf(w, "Calls with computed arguments: %v\n", strings.Join(d.Dynamic, ", "))
+	}
+
+	if d.Auth.Vault {
+		fmt.Fprintf(w, "Vault auth: %v with %v capability\n", strings.Join(d.Auth.Methods, " or "), strings.Join(d.Auth.Capabilities, ", "))
+	} else {
+		fmt.Fprintln(w, "Vault auth: not required")
+	}
+}
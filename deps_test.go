@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplateDependencies(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := writeTestFile(t, dir, "app.tmpl", "{{ .APP_NAME }} {{ vault \"secret/db/password\" }}")
+	worker := writeTestFile(t, dir, "worker.tmpl", "{{ requiredEnv \"QUEUE\" }} {{ vault \"secret/db/password\" }} {{ vault \"secret/api\" }}")
+	secrets := writeTestFile(t, dir, "secrets.tmpl", `{{ secret "vault://secret/api#token" }} {{ secret "vault://secret/db/password" }} {{ secret "env://QUEUE" }}`)
+
+	deps, err := TemplateDependencies([]string{app, worker, secrets})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := deps.WriteJSON(output); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"templates": []interface{}{app, worker, secrets},
+		"env":       []interface{}{"APP_NAME", "QUEUE"},
+		"vault": []interface{}{
+			map[string]interface{}{"path": "secret/api", "fields": []interface{}{"token", "value"}},
+			map[string]interface{}{"path": "secret/db/password", "fields": []interface{}{"value"}},
+		},
+		"auth": map[string]interface{}{"vault": true, "methods": []interface{}{"token", "app-id"}, "capabilities": []interface{}{"read"}},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("Expected %v but got %v", expected, decoded)
+	}
+
+	policy := "path \"secret/api\" {\n  capabilities = [\"read\"]\n}\n\npath \"secret/db/password\" {\n  capabilities = [\"read\"]\n}\n"
+	if actual, err := deps.Policy(false); err != nil || actual != policy {
+		t.Fatalf("Expected policy %q but got %q, %v", policy, actual, err)
+	}
+
+	dynamic := writeTestFile(t, dir, "dynamic.tmpl", "{{ vault \"secret/api\" }} {{ vault .SECRET_PATH }}")
+	deps, err = TemplateDependencies([]string{dynamic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deps.Policy(false); err == nil {
+		t.Fatalf("Expected a policy missing computed paths to fail")
+	}
+	if actual, err := deps.Policy(true); err != nil || !strings.Contains(actual, "secret/api") {
+		t.Fatalf("Expected --allow-dynamic to generate the policy but got %q, %v", actual, err)
+	}
+
+	dynamic = writeTestFile(t, dir, "dynamic_secret.tmpl", "{{ secret .SECRET_URI }}")
+	deps, err = TemplateDependencies([]string{dynamic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deps.Policy(false); err == nil {
+		t.Fatalf("Expected a policy missing computed secret URIs to fail")
+	}
+
+	// Only URIs served by the vault provider are vault paths
+	config.Providers = []string{"vault=dir", "store=vault"}
+	deps, err = TemplateDependencies([]string{writeTestFile(t, dir, "mapped.tmpl", `{{ secret "vault://secret/local" }} {{ secret "store://secret/remote#key" }}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps.Vault) != 1 || deps.Vault[0].Path != "secret/remote" || strings.Join(deps.Vault[0].Fields, ",") != "key" {
+		t.Fatalf("Expected only secret/remote#key but got %v", deps.Vault)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// ExitChanged is the exit status of --diff when any destination would change
+const ExitChanged = 2
+
+// secretFuncs are the template functions that read secrets
+var secretFuncs = []string{"vault", "secret", "file"}
+
+// DiffFile returns a unified diff from the current contents of file.Path to
+// file.Data, with every secret known to r masked. Missing files diff as empty.
+// If file is Secret, every changed line is masked whole, since the values it
+// replaces were never read and so aren't known to r.
+func DiffFile(file RenderedFile, r *Redactor) (string, error) {
+	current, err := ioutil.ReadFile(file.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	diff := UnifiedDiff(string(current), string(file.Data), file.Path, file.Path+" (rendered)")
+	if file.Secret && r.Enabled() {
+		diff = maskChangedLines(diff)
+	}
+
+	return r.Redact(diff), nil
+}
+
+// maskChangedLines replaces the content of every removed and added line of a unified diff
+func maskChangedLines(diff string) string {
+	lines := strings.SplitAfter(diff, "\n")
+	// Skip the ---/+++ header
+	for i := 2; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "-") || strings.HasPrefix(lines[i], "+") {
+			lines[i] = lines[i][:1] + mask + "\n"
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+// ReadsSecrets reports whether tmpl, or a template it includes, calls a
+// function that reads secrets. Templates that can't be inspected are assumed to.
+func ReadsSecrets(tmpl Template) bool {
+	t, ok := tmpl.(concreteTemplate)
+	if !ok {
+		return true
+	}
+
+	return len(TemplateFuncRefs(t.Template, secretFuncs...)) > 0
+}
+
+// markSecretFiles sets Secret on the files rendered from pairs whose templates read secrets
+func markSecretFiles(pairs []TemplatePair, files []RenderedFile) error {
+	for i, pair := range pairs {
+		tmpl, err := TemplateFromFile(pair.Source)
+		if err != nil {
+			return fmt.Errorf("%v: %v", pair.Source, err)
+		}
+		files[i].Secret = ReadsSecrets(tmpl)
+	}
+
+	return nil
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns a unified diff of two strings, or an empty string if they are equal
+func UnifiedDiff(from string, to string, fromName string, toName string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := diffLines(splitLines(from), splitLines(to))
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "--- %v\n+++ %v\n", fromName, toName)
+
+	hunks := 0
+	for start := 0; start < len(ops); {
+		// Find the next change and the extent of its hunk
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		end := first
+		for unchanged := 0; end < len(ops) && unchanged <= 2*diffContext; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > first && ops[end-1].kind == ' ' {
+			end--
+		}
+
+		hunkStart := first - diffContext
+		if hunkStart < start {
+			hunkStart = start
+		}
+		hunkEnd := end + diffContext
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		writeHunk(buf, ops, hunkStart, hunkEnd)
+		start = hunkEnd
+		hunks++
+	}
+
+	if hunks == 0 {
+		buf.WriteString("\\ Newline at end of file differs\n")
+	}
+
+	return buf.String()
+}
+
+func writeHunk(buf *bytes.Buffer, ops []diffOp, start int, end int) {
+	fromLine, toLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			fromLine++
+		}
+		if op.kind != '-' {
+			toLine++
+		}
+	}
+
+	fromCount, toCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			fromCount++
+		}
+		if op.kind != '-' {
+			toCount++
+		}
+	}
+
+	fmt.Fprintf(buf, "@@ -%v +%v @@\n", hunkRange(fromLine, fromCount), hunkRange(toLine, toCount))
+	for _, op := range ops[start:end] {
+		buf.WriteByte(op.kind)
+		buf.WriteString(op.line)
+		buf.WriteByte('\n')
+	}
+}
+
+func hunkRange(line int, count int) string {
+	if count == 0 {
+		line--
+	}
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+
+	return fmt.Sprintf("%v,%v", line, count)
+}
+
+func splitLines(str string) []string {
+	if len(str) == 0 {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+}
+
+// diffLines computes a line diff from the longest common subsequence of a and b
+func diffLines(a []string, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
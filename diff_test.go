@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+
+	expected := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+`
+	if diff := UnifiedDiff(from, to, "old", "new"); diff != expected {
+		t.Fatalf("Expected diff:\n%v\nbut got:\n%v", expected, diff)
+	}
+
+	if diff := UnifiedDiff(from, from, "old", "new"); diff != "" {
+		t.Fatalf("Expected no diff but got:\n%v", diff)
+	}
+
+	if diff := UnifiedDiff("", "a\n", "old", "new"); diff != "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n" {
+		t.Fatalf("Unexpected diff for a new file:\n%v", diff)
+	}
+}
+
+func TestDiffFileMasksSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Only the new value was read this run. The old one must be masked too.
+	filename := writeTestFile(t, dir, "app.env", "USER=bond\nPASSWORD=oldsecret\n")
+	r := &Redactor{}
+	r.Add("newsecret")
+
+	diff, err := DiffFile(RenderedFile{Path: filename, Data: []byte("USER=bond\nPASSWORD=newsecret\n"), Secret: true}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "--- " + filename + "\n+++ " + filename + " (rendered)\n@@ -1,2 +1,2 @@\n USER=bond\n-********\n+********\n"
+	if diff != expected {
+		t.Fatalf("Expected diff:\n%v\nbut got:\n%v", expected, diff)
+	}
+
+	// Output of templates that read no secrets is shown, apart from known secrets
+	diff, err = DiffFile(RenderedFile{Path: filename, Data: []byte("USER=bond\nPASSWORD=newsecret\n")}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-PASSWORD=oldsecret\n+PASSWORD=********\n") {
+		t.Fatalf("Expected only the known secret to be masked but got:\n%v", diff)
+	}
+
+	r.SetEnabled(false)
+	diff, err = DiffFile(RenderedFile{Path: filename, Data: []byte("USER=bond\nPASSWORD=newsecret\n"), Secret: true}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-PASSWORD=oldsecret\n+PASSWORD=newsecret\n") {
+		t.Fatalf("Expected --show-secrets to show the values but got:\n%v", diff)
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := &bytes.Buffer{}
+	context := newTestContext("BOND", "{{ vault \"secret_agents/007/last_name\" }}", output)
+	setupTest(context)
+	config.OutputFile = filepath.Join(dir, "name")
+
+	run(rootCmd, []string{})
+	validateFile(config.OutputFile, "BOND", t)
+	validateOutput(output, "", t)
+
+	context = newTestContext("BOND", "{{ vault \"secret_agents/007/last_name\" }}", output)
+	setupTest(context)
+	config.OutputFile = filepath.Join(dir, "name")
+	config.Diff = true
+
+	run(rootCmd, []string{})
+	validateOutput(output, "", t)
+}
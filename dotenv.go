@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// LoadEnvFiles parses dotenv files in order and sets their variables in env, so
+// later files override earlier ones and both override whatever env already held.
+// ${VAR} and $VAR references are expanded against the variables set so far.
+func LoadEnvFiles(filenames []string, env map[string]string) error {
+	for _, filename := range filenames {
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		if err := ParseDotenv(string(contents), env); err != nil {
+			return fmt.Errorf("%v: %v", filename, err)
+		}
+	}
+
+	return nil
+}
+
+// ParseDotenv parses KEY=VALUE lines into env. Lines may start with "export" and
+// comments start with #. Unquoted values are trimmed and expanded, single-quoted
+// values are taken literally, and double-quoted values may span lines and support
+// \n, \t, \r, \", \\ and \$ escapes as well as expansion.
+func ParseDotenv(contents string, env map[string]string) error {
+	p := dotenvParser{src: contents, line: 1, env: env}
+	for {
+		p.skip(" \t\r\n")
+		if p.done() {
+			return nil
+		}
+
+		if p.peek() == '#' {
+			p.skipLine()
+			continue
+		}
+
+		if err := p.parseAssignment(); err != nil {
+			return fmt.Errorf("line %v: %v", p.line, err)
+		}
+	}
+}
+
+type dotenvParser struct {
+	src  string
+	pos  int
+	line int
+	env  map[string]string
+}
+
+func (p *dotenvParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *dotenvParser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *dotenvParser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+
+	return c
+}
+
+func (p *dotenvParser) skip(chars string) {
+	for !p.done() && strings.IndexByte(chars, p.peek()) >= 0 {
+		p.next()
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for !p.done() && p.next() != '\n' {
+	}
+}
+
+func (p *dotenvParser) parseAssignment() error {
+	if strings.HasPrefix(p.src[p.pos:], "export ") || strings.HasPrefix(p.src[p.pos:], "export\t") {
+		p.pos += len("export")
+		p.skip(" \t")
+	}
+
+	start := p.pos
+	for !p.done() && isEnvKeyChar(p.peek(), p.pos == start) {
+		p.next()
+	}
+	key := p.src[start:p.pos]
+	if len(key) == 0 {
+		return fmt.Errorf("Expected a variable name")
+	}
+
+	p.skip(" \t")
+	if p.done() || p.peek() != '=' {
+		return fmt.Errorf("Expected = after %v", key)
+	}
+	p.next()
+	p.skip(" \t")
+
+	var val string
+	var err error
+	switch {
+	case p.done():
+	case p.peek() == '\'':
+		val, err = p.parseSingleQuoted()
+	case p.peek() == '"':
+		val, err = p.parseDoubleQuoted()
+	default:
+		val = p.parseUnquoted()
+	}
+	if err != nil {
+		return err
+	}
+
+	p.skip(" \t\r")
+	if !p.done() && p.peek() == '#' {
+		p.skipLine()
+	} else if !p.done() && p.next() != '\n' {
+		return fmt.Errorf("Unexpected characters after value of %v", key)
+	}
+
+	p.env[key] = val
+	return nil
+}
+
+func (p *dotenvParser) parseSingleQuoted() (string, error) {
+	p.next()
+	start := p.pos
+	for !p.done() && p.peek() != '\'' {
+		p.next()
+	}
+	if p.done() {
+		return "", fmt.Errorf("Unterminated single-quoted value")
+	}
+
+	val := p.src[start:p.pos]
+	p.next()
+	return val, nil
+}
+
+func (p *dotenvParser) parseDoubleQuoted() (string, error) {
+	p.next()
+	var val []byte
+	for !p.done() {
+		c := p.next()
+		switch c {
+		case '"':
+			return string(val), nil
+		case '$':
+			val = append(val, p.expand()...)
+		case '\\':
+			if p.done() {
+				return "", fmt.Errorf("Unterminated double-quoted value")
+			}
+			switch e := p.next(); e {
+			case 'n':
+				val = append(val, '\n')
+			case 't':
+				val = append(val, '\t')
+			case 'r':
+				val = append(val, '\r')
+			case '"', '\\', '$':
+				val = append(val, e)
+			default:
+				val = append(val, '\\', e)
+			}
+		default:
+			val = append(val, c)
+		}
+	}
+
+	return "", fmt.Errorf("Unterminated double-quoted value")
+}
+
+func (p *dotenvParser) parseUnquoted() string {
+	var val []byte
+	for !p.done() && p.peek() != '\n' {
+		c := p.peek()
+		if c == '#' && (len(val) == 0 || val[len(val)-1] == ' ' || val[len(val)-1] == '\t') {
+			break
+		}
+
+		p.next()
+		if c == '$' {
+			val = append(val, p.expand()...)
+		} else {
+			val = append(val, c)
+		}
+	}
+
+	return strings.TrimRight(string(val), " \t\r")
+}
+
+// expand reads the variable name following a $ and returns its value. A $
+// that isn't followed by a name is kept as is.
+func (p *dotenvParser) expand() string {
+	if !p.done() && p.peek() == '{' {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return "$"
+		}
+
+		name := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		return p.env[name]
+	}
+
+	start := p.pos
+	for !p.done() && p.peek() != '.' && isEnvKeyChar(p.peek(), p.pos == start) {
+		p.next()
+	}
+	if p.pos == start {
+		return "$"
+	}
+
+	return p.env[p.src[start:p.pos]]
+}
+
+func isEnvKeyChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return true
+	case c >= '0' && c <= '9', c == '.':
+		return !first
+	}
+
+	return false
+}
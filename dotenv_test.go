@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	contents := `# database settings
+export DB_HOST=db.internal # inline comment
+DB_PORT = 5432
+DB_URL=postgres://${DB_HOST}:$DB_PORT/app
+LITERAL='no $DB_HOST or \n here'
+QUOTED="line one\nline \"two\" \$DB_HOST ${DB_HOST}"
+MULTILINE="first
+second"
+EMPTY=
+HASH=a#b
+`
+	env := map[string]string{"HOME": "/root"}
+	if err := ParseDotenv(contents, env); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"HOME":      "/root",
+		"DB_HOST":   "db.internal",
+		"DB_PORT":   "5432",
+		"DB_URL":    "postgres://db.internal:5432/app",
+		"LITERAL":   `no $DB_HOST or \n here`,
+		"QUOTED":    "line one\nline \"two\" $DB_HOST db.internal",
+		"MULTILINE": "first\nsecond",
+		"EMPTY":     "",
+		"HASH":      "a#b",
+	}
+	if len(env) != len(expected) {
+		t.Fatalf("Expected %v but got %v", expected, env)
+	}
+	for key, val := range expected {
+		if env[key] != val {
+			t.Errorf("Expected %v=%q but got %q", key, val, env[key])
+		}
+	}
+
+	for _, invalid := range []string{"NO_EQUALS", "KEY=\"unterminated", "KEY='unterminated", "KEY=\"value\" trailing", "=value"} {
+		if err := ParseDotenv(invalid, map[string]string{}); err == nil {
+			t.Errorf("Expected %q to fail to parse", invalid)
+		}
+	}
+}
+
+func TestEnvFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := &bytes.Buffer{}
+	context := newTestContext("", "{{ .FIRST_NAME }} {{ .LAST_NAME }} {{ .CODE_NAME }}", output)
+	setupTest(context)
+	os.Setenv("FIRST_NAME", "JAMES")
+	os.Setenv("CODE_NAME", "UNSET")
+	config.EnvFiles = []string{
+		writeTestFile(t, dir, "base.env", "LAST_NAME=Bond\nCODE_NAME=${FIRST_NAME}"),
+		writeTestFile(t, dir, "override.env", "LAST_NAME=BOND\nCODE_NAME=\"00${CODE_NAME}7\""),
+	}
+
+	run(rootCmd, []string{})
+	validateOutput(output, "JAMES BOND 00JAMES7", t)
+}
+
+func TestEnvFilesLoadedPerRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	setupTest(newTestContext("", "", &bytes.Buffer{}))
+	filename := writeTestFile(t, dir, "app.env", "LAST_NAME=Bond")
+	config.EnvFiles = []string{filename}
+
+	if _, err := templateContext(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lookups during a render use the environment loaded when it started
+	writeTestFile(t, dir, "app.env", "LAST_NAME=\"unterminated")
+	if val, err := envGetString("LAST_NAME"); err != nil || val != "Bond" {
+		t.Fatalf("Expected Bond but got %q, %v", val, err)
+	}
+
+	// The next render reports the broken file instead of exiting
+	if _, err := templateContext(); err == nil || !strings.Contains(err.Error(), filename) {
+		t.Fatalf("Expected an error naming %v but got %v", filename, err)
+	}
+
+	setupTest(newTestContext("", "", &bytes.Buffer{}))
+	config.EnvFiles = []string{filename}
+	if _, err := hasEnv("LAST_NAME"); err == nil {
+		t.Fatalf("Expected a lookup to report the broken file")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// etcdKeyNotFound is the etcd error code for a missing key
+const etcdKeyNotFound = 100
+
+// EtcdKV reads values from the etcd v2 keys API, trying each address in turn
+type EtcdKV struct {
+	addrs  []string
+	client *http.Client
+}
+
+// etcdNode is a key or directory in a keys API response
+type etcdNode struct {
+	Key   string      `json:"key"`
+	Value string      `json:"value"`
+	Dir   bool        `json:"dir"`
+	Nodes []*etcdNode `json:"nodes"`
+}
+
+// etcdResponse is the body of a keys API response, holding either a node or an error
+type etcdResponse struct {
+	Node      *etcdNode `json:"node"`
+	ErrorCode int       `json:"errorCode"`
+	Message   string    `json:"message"`
+}
+
+// NewEtcdKV creates an etcd client using the given config, authenticating
+// with a TLS client certificate if one is configured
+func NewEtcdKV(config Config) (KV, error) {
+	addrs := config.EtcdAddrs
+	if len(addrs) == 0 {
+		addrs = []string{"http://127.0.0.1:2379"}
+	}
+
+	client := &http.Client{}
+	if len(config.EtcdCert) > 0 || len(config.EtcdKey) > 0 || len(config.EtcdCA) > 0 {
+		tlsConfig, err := etcdTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}
+	}
+
+	return EtcdKV{addrs: addrs, client: client}, nil
+}
+
+// etcdTLSConfig loads the configured client certificate and CA. Servers are
+// always verified, against the system roots if no CA is given.
+func etcdTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if (len(config.EtcdCert) > 0) != (len(config.EtcdKey) > 0) {
+		return nil, fmt.Errorf("Invalid etcd client certificate. Please specify a certificate AND key")
+	}
+
+	if len(config.EtcdCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.EtcdCert, config.EtcdKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.EtcdCA) > 0 {
+		pem, err := ioutil.ReadFile(config.EtcdCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %v", config.EtcdCA)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// Get returns the value of key, failing if it doesn't exist or is a directory
+func (e EtcdKV) Get(key string) (string, error) {
+	node, err := e.get(key, false)
+	if err != nil {
+		return "", err
+	}
+	if node.Dir {
+		return "", fmt.Errorf("Etcd key %v is a directory", key)
+	}
+
+	return node.Value, nil
+}
+
+// Tree returns every key under prefix with prefix removed. Keys in nested
+// directories keep their path, e.g. db/host.
+func (e EtcdKV) Tree(prefix string) (map[string]string, error) {
+	node, err := e.get(prefix, true)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(map[string]string)
+	root := strings.TrimSuffix(node.Key, "/") + "/"
+	var walk func(nodes []*etcdNode)
+	walk = func(nodes []*etcdNode) {
+		for _, node := range nodes {
+			if node.Dir {
+				walk(node.Nodes)
+				continue
+			}
+			tree[strings.TrimPrefix(node.Key, root)] = node.Value
+		}
+	}
+	walk(node.Nodes)
+
+	return tree, nil
+}
+
+// get reads key from the first address that answers, with its whole tree
+// in sorted order if recursive is set
+func (e EtcdKV) get(key string, recursive bool) (*etcdNode, error) {
+	query := url.Values{}
+	if recursive {
+		query.Set("recursive", "true")
+		query.Set("sorted", "true")
+	}
+
+	var err error
+	for _, addr := range e.addrs {
+		var u *url.URL
+		if u, err = url.Parse(addr); err != nil {
+			continue
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v2/keys/" + strings.TrimPrefix(key, "/")
+		u.RawQuery = query.Encode()
+
+		var resp *http.Response
+		if resp, err = e.client.Get(u.String()); err != nil {
+			continue
+		}
+
+		var body etcdResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			err = fmt.Errorf("Invalid response from %v: %v", addr, err)
+			continue
+		}
+
+		if body.ErrorCode == etcdKeyNotFound {
+			return nil, fmt.Errorf("Etcd key %v not found", key)
+		}
+		if body.ErrorCode != 0 {
+			return nil, fmt.Errorf("Error reading %v from etcd: %v (%v)", key, body.Message, body.ErrorCode)
+		}
+		if body.Node == nil {
+			return nil, fmt.Errorf("Error reading %v from etcd: %v returned no node", key, addr)
+		}
+
+		return body.Node, nil
+	}
+
+	return nil, fmt.Errorf("Error reading %v from etcd: %v", key, err)
+}
+
+func etcdKV() (KV, error) {
+	factory := config.EtcdFactory
+	if factory == nil {
+		factory = NewEtcdKV
+	}
+
+	return openKV(&etcd, "etcd", factory)
+}
+
+func etcdGetString(key string) (string, error) {
+	return kvGetString(etcdKV, key)
+}
+
+func etcdGetTree(prefix string) (map[string]string, error) {
+	return kvGetTree(etcdKV, prefix)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type etcdTestNode struct {
+	Key   string          `json:"key"`
+	Value string          `json:"value,omitempty"`
+	Dir   bool            `json:"dir,omitempty"`
+	Nodes []*etcdTestNode `json:"nodes,omitempty"`
+}
+
+// etcdHandler serves the etcd v2 keys read API from values, which maps leaf keys to their values
+func etcdHandler(values map[string]string) http.Handler {
+	var node func(key string) *etcdTestNode
+	node = func(key string) *etcdTestNode {
+		if val, ok := values[key]; ok {
+			return &etcdTestNode{Key: key, Value: val}
+		}
+
+		children := make(map[string]bool)
+		for k := range values {
+			if strings.HasPrefix(k, strings.TrimSuffix(key, "/")+"/") {
+				rest := strings.TrimPrefix(k, strings.TrimSuffix(key, "/")+"/")
+				children[strings.TrimSuffix(key, "/")+"/"+strings.SplitN(rest, "/", 2)[0]] = true
+			}
+		}
+		if len(children) == 0 {
+			return nil
+		}
+
+		dir := &etcdTestNode{Key: strings.TrimSuffix(key, "/"), Dir: true}
+		for _, child := range sortedKeys(children) {
+			dir.Nodes = append(dir.Nodes, node(child))
+		}
+		return dir
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Etcd-Index", "1")
+
+		key := strings.TrimPrefix(r.URL.Path, "/v2/keys")
+		n := node(key)
+		if n == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errorCode": 100, "message": "Key not found", "cause": key, "index": 1})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"action": "get", "node": n})
+	})
+}
+
+var etcdTestValues = map[string]string{
+	"/app/key":          "value",
+	"/app/db/host":      "db.internal",
+	"/app/db/port":      "2379",
+	"/app/feature_flag": "on",
+	"/other/key":        "other",
+}
+
+func TestEtcdKV(t *testing.T) {
+	server := httptest.NewServer(etcdHandler(etcdTestValues))
+	defer server.Close()
+
+	kv, err := NewEtcdKV(Config{EtcdAddrs: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateEtcdKV(kv, t)
+
+	// Addresses that don't answer are skipped
+	kv, err = NewEtcdKV(Config{EtcdAddrs: []string{"http://127.0.0.1:1", server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateEtcdKV(kv, t)
+}
+
+func TestEtcdKVClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := newTestCert(t, dir, "ca", nil, nil)
+	newTestCert(t, dir, "server", ca, caKey)
+	newTestCert(t, dir, "client", ca, caKey)
+
+	serverKeyPair, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(etcdHandler(etcdTestValues))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverKeyPair}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	config := Config{
+		EtcdAddrs: []string{server.URL},
+		EtcdCert:  filepath.Join(dir, "client.crt"),
+		EtcdKey:   filepath.Join(dir, "client.key"),
+		EtcdCA:    filepath.Join(dir, "ca.crt"),
+	}
+	kv, err := NewEtcdKV(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateEtcdKV(kv, t)
+
+	// Without a client certificate the server refuses the connection
+	config.EtcdCert, config.EtcdKey = "", ""
+	kv, err = NewEtcdKV(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get("/app/key"); err == nil {
+		t.Fatalf("Expected a request without a client certificate to fail")
+	}
+
+	config.EtcdCert = filepath.Join(dir, "client.crt")
+	if _, err := NewEtcdKV(config); err == nil {
+		t.Fatalf("Expected a certificate without a key to be invalid")
+	}
+}
+
+func validateEtcdKV(kv KV, t *testing.T) {
+	val, err := kv.Get("/app/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "value" {
+		t.Fatalf("Expected value but got %v", val)
+	}
+
+	if _, err := kv.Get("/app/missing"); err == nil || !strings.Contains(err.Error(), "/app/missing not found") {
+		t.Fatalf("Expected a missing key to fail but got %v", err)
+	}
+	if _, err := kv.Get("/app/db"); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Fatalf("Expected a directory to fail but got %v", err)
+	}
+
+	tree, err := kv.Tree("/app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"key": "value", "db/host": "db.internal", "db/port": "2379", "feature_flag": "on"}
+	if len(tree) != len(expected) {
+		t.Fatalf("Expected tree %v but got %v", expected, tree)
+	}
+	for k, v := range expected {
+		if tree[k] != v {
+			t.Fatalf("Expected tree %v but got %v", expected, tree)
+		}
+	}
+}
+
+func TestEtcdFunctions(t *testing.T) {
+	server := httptest.NewServer(etcdHandler(etcdTestValues))
+	defer server.Close()
+
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+	config.EtcdAddrs = []string{server.URL}
+
+	tmpl, err := TemplateFromString(`{{ etcd "/app/key" }}{{ range $k, $v := etcdTree "/app/db" }} {{ $k }}={{ $v }}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "value host=db.internal port=2379", t)
+}
+
+// newTestCert writes name.crt and name.key to dir, signed by parent or self-signed if parent is nil
+func newTestCert(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, dir, name+".crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeTestFile(t, dir, name+".key", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})))
+
+	return cert, key
+}
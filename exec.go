@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// OnChangeRestart is the --on-change policy that restarts the child with its new environment
+const OnChangeRestart = "restart"
+
+// restartTimeout is how long a child may take to exit after SIGTERM before it is killed
+const restartTimeout = 10 * time.Second
+
+// forwardedSignals are passed on to the child process
+var forwardedSignals = []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGWINCH}
+
+var execCmd = &cobra.Command{
+	Use:   "exec --env-template <filename> -- <command> [args...]",
+	Short: "Run a command with a rendered template as its environment",
+	Long: "Renders a template of KEY=VALUE lines and runs a command with those variables added to its environment. " +
+		"Nothing is written to disk. Signals are forwarded to the command and polymerase exits with its exit status. " +
+		"With --watch the template is re-rendered when its inputs or, with --refresh, its secrets change, and the command is restarted or signalled.",
+	Example: "polymerase exec --env-template app.env.tmpl -- ./server\npolymerase exec --env-template app.env.tmpl --watch --refresh 5m --on-change HUP -- ./server",
+	Run:     runExec,
+}
+
+func init() {
+	execCmd.Flags().StringVar(&config.EnvTemplate, "env-template", "", "Template of KEY=VALUE lines to add to the command's environment.")
+	execCmd.Flags().BoolVar(&config.Watch, "watch", false, "Re-render the environment when the template's inputs change.")
+	execCmd.Flags().DurationVar(&config.Refresh, "refresh", 0, "With --watch, how often to poll vault for changed secrets. 0 disables polling.")
+	execCmd.Flags().DurationVar(&config.Debounce, "debounce", 250*time.Millisecond, "With --watch, how long to wait for changes to settle before re-rendering.")
+	execCmd.Flags().StringVar(&config.OnChange, "on-change", OnChangeRestart, "What to do when the environment changes: restart, or the name of a signal to send.")
+	rootCmd.AddCommand(execCmd)
+}
+
+func runExec(cmd *cobra.Command, args []string) {
+	if len(config.EnvTemplate) == 0 || len(args) == 0 {
+		cmd.Usage()
+		return
+	}
+
+	s := &Supervisor{Command: args, OnChange: config.OnChange, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	if s.OnChange != OnChangeRestart {
+		if _, err := parseSignal(s.OnChange); err != nil {
+			logger.Fatalf("Error reading --on-change: %v", err)
+		}
+	}
+
+	if _, err := config.Validate(); err != nil {
+		logger.Fatalf("Error validating config: %v", err)
+	}
+	configureVault()
+
+	environment, err := renderEnv(config.EnvTemplate)
+	if err != nil {
+		logger.Fatalf("Error populating template: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+
+	changes := make(chan []string)
+	failures := make(chan error, 1)
+	if config.Watch {
+		pairs := []TemplatePair{{Source: config.EnvTemplate}}
+		w := &Watcher{
+			Inputs:   func() ([]string, error) { return watchInputs(pairs) },
+			Debounce: config.Debounce,
+			Render: func() error {
+				environment, err := renderEnv(config.EnvTemplate)
+				if err != nil {
+					return err
+				}
+				changes <- environment
+				return nil
+			},
+			Refresher: &Refresher{Vault: vault.(*cachingVault), Pairs: pairs, Interval: config.Refresh},
+		}
+		go func() {
+			if err := w.Run(make(chan struct{})); err != nil {
+				failures <- fmt.Errorf("Error watching templates: %v", err)
+			}
+		}()
+	}
+
+	status, err := s.Run(environment, changes, signals, failures)
+	if err != nil {
+		logger.Fatalf("Error running command: %v", err)
+	}
+
+	if config.RevokeLeases {
+		revokeLeases()
+	}
+	exit(status)
+}
+
+// renderEnv renders filename and returns the process environment, including
+// --env-file variables, with the KEY=VALUE lines it rendered added
+func renderEnv(filename string) ([]string, error) {
+	data, err := templateContext()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := renderFile(filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := env()
+	if err != nil {
+		return nil, err
+	}
+	if err := parseEnvLines(string(out), environment); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	var list []string
+	for key, val := range environment {
+		list = append(list, key+"="+val)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// parseEnvLines sets the KEY=VALUE lines of str in env. Blank lines and lines
+// starting with # are skipped. Unlike dotenv files, values are taken literally
+// so rendered secrets are never unquoted or expanded.
+func parseEnvLines(str string, env map[string]string) error {
+	for i, line := range strings.Split(str, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		key, val, ok := envKeyVal(strings.TrimPrefix(strings.TrimLeft(line, " \t"), "export "))
+		if !ok || !validEnvKey(key) {
+			return fmt.Errorf("line %v: Expected KEY=VALUE", i+1)
+		}
+		env[key] = val
+	}
+
+	return nil
+}
+
+func validEnvKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if !isEnvKeyChar(key[i], i == 0) {
+			return false
+		}
+	}
+
+	return len(key) > 0
+}
+
+// Supervisor runs a command as a child process and replaces or signals it when its environment changes
+type Supervisor struct {
+	Command  []string
+	OnChange string
+	Stdin    io.Reader
+	Stdout   io.Writer
+	Stderr   io.Writer
+}
+
+// Run starts the command with environment and waits for it to exit, returning
+// its exit status. Signals are forwarded to the command, except SIGINT when it
+// runs in the terminal's foreground and gets it from the terminal. An environment
+// received on changes that differs from the current one restarts the
+// command, or sends it the OnChange signal. An error received on failures
+// stops the command and is returned once it has exited.
+func (s *Supervisor) Run(environment []string, changes <-chan []string, signals <-chan os.Signal, failures <-chan error) (int, error) {
+	tty, foreground := s.terminal()
+	if foreground {
+		defer takeForeground(tty)
+	}
+
+	child, exited, err := s.start(environment)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if foreground && sig == syscall.SIGINT {
+				continue
+			}
+			child.Process.Signal(sig)
+		case changed := <-changes:
+			if strings.Join(changed, "\x00") == strings.Join(environment, "\x00") {
+				continue
+			}
+			environment = changed
+
+			if s.OnChange != OnChangeRestart {
+				sig, err := parseSignal(s.OnChange)
+				if err != nil {
+					return 0, err
+				}
+				logger.Printf("Environment changed, sending %v to %v", sig, s.Command[0])
+				child.Process.Signal(sig)
+				continue
+			}
+
+			logger.Printf("Environment changed, restarting %v", s.Command[0])
+			stopChild(child, exited)
+			if child, exited, err = s.start(environment); err != nil {
+				return 0, err
+			}
+		case err := <-failures:
+			stopChild(child, exited)
+			return 0, err
+		case err := <-exited:
+			return exitStatus(err), nil
+		}
+	}
+}
+
+func (s *Supervisor) start(environment []string) (*exec.Cmd, <-chan error, error) {
+	child := exec.Command(s.Command[0], s.Command[1:]...)
+	child.Env = environment
+	child.Stdin = s.Stdin
+	child.Stdout = s.Stdout
+	child.Stderr = s.Stderr
+	if tty, ok := s.terminal(); ok {
+		// A child reading the terminal from a background process group would
+		// be stopped, so hand it the foreground
+		child.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: tty}
+	} else {
+		// Signals are forwarded, so keep the child out of polymerase's process
+		// group or a Ctrl-C in the terminal would reach it twice
+		child.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if err := child.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- child.Wait() }()
+
+	return child, exited, nil
+}
+
+// terminal returns the descriptor of the command's stdin if it is a terminal
+func (s *Supervisor) terminal() (int, bool) {
+	f, ok := s.Stdin.(*os.File)
+	if !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+
+	return int(f.Fd()), true
+}
+
+// takeForeground moves polymerase's process group back to the foreground of
+// tty once the child has exited. Only the foreground group may do so without
+// SIGTTOU stopping it, so the signal is ignored meanwhile.
+func takeForeground(tty int) {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+
+	pgrp := int32(syscall.Getpgrp())
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(tty), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+}
+
+// stopChild sends SIGTERM to child and waits for it to exit, killing it after restartTimeout
+func stopChild(child *exec.Cmd, exited <-chan error) {
+	child.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(restartTimeout):
+		child.Process.Kill()
+		<-exited
+	}
+}
+
+// exitStatus returns the exit status of a finished command, or 128 plus the
+// signal number if a signal killed it, as a shell would
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+
+	return 1
+}
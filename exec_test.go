@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseEnvLines(t *testing.T) {
+	env := map[string]string{"HOME": "/root"}
+	err := parseEnvLines("# secrets\nDB_PASSWORD=p@$$w0rd\"'\n\nexport API_KEY= spaced \nHOME=/app\r\n", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"HOME": "/app", "DB_PASSWORD": "p@$$w0rd\"'", "API_KEY": " spaced "}
+	for key, val := range expected {
+		if env[key] != val {
+			t.Fatalf("Expected %v=%q but got %q", key, val, env[key])
+		}
+	}
+
+	for _, invalid := range []string{"NO_VALUE", "=value", "1KEY=value", "BAD-KEY=value"} {
+		if err := parseEnvLines(invalid, map[string]string{}); err == nil {
+			t.Fatalf("Line %q was valid but should have been invalid", invalid)
+		}
+	}
+}
+
+func TestRenderEnv(t *testing.T) {
+	context := newTestContext("s3cr3t", "", &bytes.Buffer{})
+	setupTest(context)
+	configureVault()
+
+	dir, err := ioutil.TempDir("", "polymerase_test_exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("POLYMERASE_TEST_EXEC", "inherited")
+	environment, err := renderEnv(writeTestFile(t, dir, "app.env.tmpl", "SECRET={{ vault \"secret/app\" }}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined := strings.Join(environment, "\n")
+	for _, expected := range []string{"SECRET=s3cr3t", "POLYMERASE_TEST_EXEC=inherited"} {
+		if !strings.Contains(joined, expected) {
+			t.Fatalf("Expected %v in environment %v", expected, environment)
+		}
+	}
+}
+
+func TestSupervisorExitStatus(t *testing.T) {
+	output := &bytes.Buffer{}
+	s := &Supervisor{Command: []string{"sh", "-c", "echo $SECRET; exit 3"}, OnChange: OnChangeRestart, Stdout: output}
+
+	status, err := s.Run([]string{"SECRET=one"}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 3 {
+		t.Fatalf("Expected exit status 3 but got %v", status)
+	}
+	validateOutput(output, "one\n", t)
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	output := &bytes.Buffer{}
+	s := &Supervisor{Command: []string{"sh", "-c", "echo $SECRET; exec sleep 5"}, OnChange: OnChangeRestart, Stdout: output}
+
+	changes := make(chan []string)
+	signals := make(chan os.Signal)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		changes <- []string{"SECRET=one"}
+		changes <- []string{"SECRET=two"}
+		time.Sleep(100 * time.Millisecond)
+		signals <- syscall.SIGTERM
+	}()
+
+	status, err := s.Run([]string{"SECRET=one"}, changes, signals, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 128+int(syscall.SIGTERM) {
+		t.Fatalf("Expected the forwarded SIGTERM to stop the command but got status %v", status)
+	}
+	validateOutput(output, "one\ntwo\n", t)
+}
+
+func TestSupervisorSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "reloaded")
+	s := &Supervisor{Command: []string{"sh", "-c", "trap 'touch " + marker + "; exit 0' HUP; while true; do sleep 0.05; done"}, OnChange: "HUP"}
+
+	changes := make(chan []string)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		changes <- []string{"SECRET=two"}
+	}()
+
+	status, err := s.Run([]string{"SECRET=one"}, changes, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 0 {
+		t.Fatalf("Expected exit status 0 but got %v", status)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("Expected the command to receive SIGHUP: %v", err)
+	}
+}
+
+func TestSupervisorFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "stopped")
+	s := &Supervisor{Command: []string{"sh", "-c", "trap 'touch " + marker + "; exit 0' TERM; while true; do sleep 0.05; done"}, OnChange: OnChangeRestart}
+
+	failures := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		failures <- fmt.Errorf("watcher failed")
+	}()
+
+	if _, err := s.Run([]string{"SECRET=one"}, nil, nil, failures); err == nil || err.Error() != "watcher failed" {
+		t.Fatalf("Expected the watcher's error but got %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("Expected the command to be stopped before returning: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// FixtureVault serves secrets from a fixtures file instead of vault, so
+// templates can be rendered and tested offline
+type FixtureVault struct {
+	filename string
+	secrets  map[string]map[string]interface{}
+}
+
+// LoadVaultFixtures reads a YAML, JSON, TOML or HCL file mapping vault paths
+// to their data. A path mapped to a single value is shorthand for its value field.
+func LoadVaultFixtures(filename string) (*FixtureVault, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decodeDataFile(filename, string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	paths, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v: expected a map of vault paths at the top level but got %T", filename, decoded)
+	}
+
+	f := &FixtureVault{filename: filename, secrets: make(map[string]map[string]interface{}, len(paths))}
+	for path, data := range paths {
+		path = strings.Trim(path, "/")
+		if fields, ok := data.(map[string]interface{}); ok {
+			f.secrets[path] = fields
+		} else {
+			f.secrets[path] = map[string]interface{}{"value": data}
+		}
+	}
+
+	return f, nil
+}
+
+// GetStringValue returns the value field of the secret at path
+func (f *FixtureVault) GetStringValue(path string) (string, error) {
+	return f.GetStringField(path, "value")
+}
+
+// GetStringField returns field of the secret at path. Numbers and booleans are formatted as strings.
+func (f *FixtureVault) GetStringField(path string, field string) (string, error) {
+	fields, ok := f.secrets[strings.Trim(path, "/")]
+	if !ok {
+		return "", fmt.Errorf("Vault path %v not found in fixtures %v", path, f.filename)
+	}
+
+	val, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("Vault path %v has no field %v in fixtures %v. Fields: %v", path, field, f.filename, strings.Join(fixtureFields(fields), ", "))
+	}
+
+	switch val.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("Vault path %v field %v in fixtures %v is not a single value", path, field, f.filename)
+	case nil:
+		return "", nil
+	}
+
+	return fmt.Sprint(val), nil
+}
+
+// ListKeys returns the keys directly under path the way vault lists them,
+// with a trailing slash on keys that have keys of their own
+func (f *FixtureVault) ListKeys(path string) ([]string, error) {
+	prefix := strings.Trim(path, "/") + "/"
+
+	seen := make(map[string]bool)
+	var keys []string
+	for secret := range f.secrets {
+		if !strings.HasPrefix(secret, prefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(secret, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Vault path %v not found in fixtures %v", path, f.filename)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func fixtureFields(fields map[string]interface{}) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
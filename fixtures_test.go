@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testFixtures = `
+secret/api: api-fixture-key
+secret/db:
+  value: postgres://db.internal/app
+  password: fixture-password
+  port: 5432
+  hosts: [db1, db2]
+secret/app/one/config: one
+`
+
+func TestVaultFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := writeTestFile(t, dir, "secrets.yaml", testFixtures)
+	f, err := LoadVaultFixtures(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for secret, expected := range map[[2]string]string{
+		{"secret/api", "value"}:   "api-fixture-key",
+		{"/secret/db", "value"}:   "postgres://db.internal/app",
+		{"secret/db", "port"}:     "5432",
+		{"secret/db", "password"}: "fixture-password",
+	} {
+		val, err := f.GetStringField(secret[0], secret[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != expected {
+			t.Fatalf("Expected %v for %v but got %v", expected, secret, val)
+		}
+	}
+
+	for path, expected := range map[string]string{
+		"secret/missing#value": "Vault path secret/missing not found in fixtures " + filename,
+		"secret/db#username":   "has no field username in fixtures " + filename + ". Fields: hosts, password, port, value",
+		"secret/db#hosts":      "is not a single value",
+	} {
+		spl := strings.SplitN(path, "#", 2)
+		if _, err := f.GetStringField(spl[0], spl[1]); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %v to fail with %q but got %v", path, expected, err)
+		}
+	}
+
+	keys, err := f.ListKeys("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "api,app/,db" {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+
+	if _, err := LoadVaultFixtures(writeTestFile(t, dir, "list.yaml", "- secret/db\n")); err == nil {
+		t.Fatalf("Expected fixtures without a top level map to fail")
+	}
+}
+
+func TestVaultFixturesRender(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Fixtures need no vault address or credentials and never reach vault
+	config.VaultAddr = ""
+	config.VaultToken = ""
+	config.VaultFactoryFunc = func(Config) (Vault, error) {
+		return nil, fmt.Errorf("Expected vault not to be configured")
+	}
+	config.VaultFixtures = writeTestFile(t, dir, "secrets.yaml", testFixtures)
+	configureVault()
+
+	tmpl, err := TemplateFromString(`{{ vault "secret/api" }} {{ secret "vault://secret/db#password" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "api-fixture-key fixture-password", t)
+}
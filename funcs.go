@@ -0,0 +1,476 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// builtinFuncs returns the function library available to every template in
+// addition to vault, env and the text/template builtins. Argument order follows
+// the convention of putting the piped value last, e.g. {{ .NAME | default "none" }}.
+func builtinFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Defaults
+		"default":  defaultValue,
+		"empty":    empty,
+		"coalesce": coalesce,
+		"ternary":  ternary,
+
+		// Strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      title,
+		"trim":       strings.TrimSpace,
+		"trimAll":    func(cutset string, s string) string { return strings.Trim(s, cutset) },
+		"trimPrefix": func(prefix string, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix string, s string) string { return strings.TrimSuffix(s, suffix) },
+		"contains":   func(substr string, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix string, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix string, s string) bool { return strings.HasSuffix(s, suffix) },
+		"replace":    func(old string, new string, s string) string { return strings.Replace(s, old, new, -1) },
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"split":      func(sep string, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+		"indent":     indent,
+		"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+		"quote":      quote,
+		"squote":     squote,
+		"toString":   toString,
+
+		// Regular expressions
+		"regexMatch":      regexMatch,
+		"regexFind":       regexFind,
+		"regexReplaceAll": regexReplaceAll,
+
+		// Lists
+		"list":      func(items ...interface{}) []interface{} { return items },
+		"first":     first,
+		"last":      last,
+		"rest":      rest,
+		"has":       has,
+		"uniq":      uniq,
+		"sortAlpha": sortAlpha,
+
+		// Maps
+		"dict":   dict,
+		"get":    func(m map[string]interface{}, key string) interface{} { return m[key] },
+		"set":    set,
+		"hasKey": func(m map[string]interface{}, key string) bool { _, ok := m[key]; return ok },
+		"keys":   keys,
+
+		// Math
+		"add":  add,
+		"sub":  sub,
+		"mul":  mul,
+		"div":  div,
+		"mod":  mod,
+		"max":  func(a interface{}, b interface{}) (int64, error) { return arith(a, b, max64) },
+		"min":  func(a interface{}, b interface{}) (int64, error) { return arith(a, b, min64) },
+		"atoi": func(s string) (int, error) { return strconv.Atoi(strings.TrimSpace(s)) },
+
+		// Encoding
+		"toJson":       toJSON,
+		"toPrettyJson": toPrettyJSON,
+		"toYaml":       toYAML,
+		"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":       b64dec,
+		"fromYaml":     fromYAML,
+		"fromJson":     fromJSON,
+		"fromToml":     fromTOML,
+		"fromHcl":      fromHCL,
+
+		// Hashing
+		"sha1sum":   func(s string) string { sum := sha1.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+		"sha256sum": func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+
+		// Dates
+		"now":       time.Now,
+		"date":      date,
+		"unixEpoch": func(t time.Time) int64 { return t.Unix() },
+		"duration":  time.ParseDuration,
+	}
+}
+
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return def
+	}
+
+	return given[0]
+}
+
+// empty reports whether val is nil or the zero value of its type
+func empty(val interface{}) bool {
+	v := reflect.ValueOf(val)
+	if !v.IsValid() {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return reflect.DeepEqual(val, reflect.Zero(v.Type()).Interface())
+}
+
+func coalesce(vals ...interface{}) interface{} {
+	for _, val := range vals {
+		if !empty(val) {
+			return val
+		}
+	}
+
+	return nil
+}
+
+func ternary(ifTrue interface{}, ifFalse interface{}, cond bool) interface{} {
+	if cond {
+		return ifTrue
+	}
+
+	return ifFalse
+}
+
+// title upper cases the first letter of every word, leaving the spacing between them as it is
+func title(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+
+	return string(runes)
+}
+
+func join(sep string, list interface{}) (string, error) {
+	strs, err := toStrings(list)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(strs, sep), nil
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func quote(vals ...interface{}) string {
+	quoted := make([]string, len(vals))
+	for i, val := range vals {
+		quoted[i] = strconv.Quote(toString(val))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func squote(vals ...interface{}) string {
+	quoted := make([]string, len(vals))
+	for i, val := range vals {
+		quoted[i] = "'" + toString(val) + "'"
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func toString(val interface{}) string {
+	switch val := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case fmt.Stringer:
+		return val.String()
+	}
+
+	return fmt.Sprint(val)
+}
+
+func regexMatch(regex string, s string) (bool, error) {
+	return regexp.MatchString(regex, s)
+}
+
+func regexFind(regex string, s string) (string, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+
+	return re.FindString(s), nil
+}
+
+func regexReplaceAll(regex string, repl string, s string) (string, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// toList converts any slice or array to a []interface{}
+func toList(list interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("Expected a list but got %T", list)
+	}
+
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+
+	return items, nil
+}
+
+func toStrings(list interface{}) ([]string, error) {
+	items, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, len(items))
+	for i, item := range items {
+		strs[i] = toString(item)
+	}
+
+	return strs, nil
+}
+
+func first(list interface{}) (interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	return items[0], nil
+}
+
+func last(list interface{}) (interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	return items[len(items)-1], nil
+}
+
+func rest(list interface{}) ([]interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	return items[1:], nil
+}
+
+func has(needle interface{}, list interface{}) (bool, error) {
+	items, err := toList(list)
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range items {
+		if reflect.DeepEqual(item, needle) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func uniq(list interface{}) ([]interface{}, error) {
+	items, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var unique []interface{}
+	for _, item := range items {
+		if found, _ := has(item, unique); !found {
+			unique = append(unique, item)
+		}
+	}
+
+	return unique, nil
+}
+
+func sortAlpha(list interface{}) ([]string, error) {
+	strs, err := toStrings(list)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(strs)
+
+	return strs, nil
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict requires an even number of arguments, got %v", len(pairs))
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		m[toString(pairs[i])] = pairs[i+1]
+	}
+
+	return m, nil
+}
+
+// set changes the map in place and returns it for chaining
+func set(m map[string]interface{}, key string, val interface{}) map[string]interface{} {
+	m[key] = val
+	return m
+}
+
+func keys(m interface{}) ([]string, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map {
+		return nil, fmt.Errorf("Expected a map but got %T", m)
+	}
+
+	strs := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		strs = append(strs, toString(key.Interface()))
+	}
+	sort.Strings(strs)
+
+	return strs, nil
+}
+
+// toInt64 converts integers, whole floats and numeric strings to an int64
+func toInt64(val interface{}) (int64, error) {
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); f != float64(int64(f)) {
+			return 0, fmt.Errorf("Expected an integer but got %v", f)
+		}
+		return int64(v.Float()), nil
+	case reflect.String:
+		return strconv.ParseInt(strings.TrimSpace(v.String()), 10, 64)
+	}
+
+	return 0, fmt.Errorf("Expected a number but got %T", val)
+}
+
+func arith(a interface{}, b interface{}, op func(int64, int64) int64) (int64, error) {
+	x, err := toInt64(a)
+	if err != nil {
+		return 0, err
+	}
+
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return op(x, y), nil
+}
+
+func add(a interface{}, b interface{}) (int64, error) {
+	return arith(a, b, func(x, y int64) int64 { return x + y })
+}
+
+func sub(a interface{}, b interface{}) (int64, error) {
+	return arith(a, b, func(x, y int64) int64 { return x - y })
+}
+
+func mul(a interface{}, b interface{}) (int64, error) {
+	return arith(a, b, func(x, y int64) int64 { return x * y })
+}
+
+func div(a interface{}, b interface{}) (int64, error) {
+	if y, err := toInt64(b); err == nil && y == 0 {
+		return 0, fmt.Errorf("Division by zero")
+	}
+
+	return arith(a, b, func(x, y int64) int64 { return x / y })
+}
+
+func mod(a interface{}, b interface{}) (int64, error) {
+	if y, err := toInt64(b); err == nil && y == 0 {
+		return 0, fmt.Errorf("Division by zero")
+	}
+
+	return arith(a, b, func(x, y int64) int64 { return x % y })
+}
+
+func max64(x int64, y int64) int64 {
+	if x > y {
+		return x
+	}
+
+	return y
+}
+
+func min64(x int64, y int64) int64 {
+	if x < y {
+		return x
+	}
+
+	return y
+}
+
+func toJSON(val interface{}) (string, error) {
+	out, err := json.Marshal(val)
+	return string(out), err
+}
+
+func toPrettyJSON(val interface{}) (string, error) {
+	out, err := json.MarshalIndent(val, "", "  ")
+	return string(out), err
+}
+
+func toYAML(val interface{}) (string, error) {
+	out, err := yaml.Marshal(val)
+	return strings.TrimSuffix(string(out), "\n"), err
+}
+
+func b64dec(s string) (string, error) {
+	out, err := base64.StdEncoding.DecodeString(s)
+	return string(out), err
+}
+
+// date formats a time.Time, or a unix timestamp, using a Go reference layout
+func date(layout string, t interface{}) (string, error) {
+	switch t := t.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case *time.Time:
+		return t.Format(layout), nil
+	}
+
+	secs, err := toInt64(t)
+	if err != nil {
+		return "", err
+	}
+
+	return time.Unix(secs, 0).Format(layout), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBuiltinFuncs(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+
+	data := map[string]interface{}{
+		"Empty": "",
+		"Name":  "james bond",
+		"Hosts": []string{"db2", "db1", "db2"},
+		"Port":  "5432",
+		"Config": map[string]interface{}{
+			"user": "bond",
+			"ids":  []int{0, 0, 7},
+		},
+		"Time": time.Date(2016, 11, 3, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{ .Empty | default "none" }}`, "none"},
+		{`{{ .Name | default "none" }}`, "james bond"},
+		{`{{ empty .Empty }} {{ empty .Name }}`, "true false"},
+		{`{{ coalesce .Empty "" "first" "second" }}`, "first"},
+		{`{{ ternary "yes" "no" true }}`, "yes"},
+		{`{{ .Name | upper }} {{ "LOUD" | lower }} {{ .Name | title }}`, "JAMES BOND loud James Bond"},
+		{`{{ "élan  vital\tforce" | title }}`, "Élan  Vital\tForce"},
+		{`{{ "  padded  " | trim }}|{{ "--x--" | trimAll "-" }}|{{ "v1.2" | trimPrefix "v" }}|{{ "app.tmpl" | trimSuffix ".tmpl" }}`, "padded|x|1.2|app"},
+		{`{{ contains "bond" .Name }} {{ hasPrefix "james" .Name }} {{ hasSuffix "x" .Name }}`, "true true false"},
+		{`{{ .Name | replace " " "_" }} {{ repeat 3 "ab" }}`, "james_bond ababab"},
+		{`{{ "a,b,c" | split "," | join "-" }} {{ join "," .Hosts }}`, "a-b-c db2,db1,db2"},
+		{`{{ "a\nb" | indent 2 }}|{{ "a" | nindent 2 }}`, "  a\n  b|\n  a"},
+		{`{{ quote .Name 7 }} {{ squote .Name }}`, `"james bond" "7" 'james bond'`},
+		{`{{ regexMatch "^[a-z ]+$" .Name }} {{ regexFind "[0-9]+" "db12x" }} {{ .Name | regexReplaceAll "b(o)" "${1}0" }}`, "true 12 james o0nd"},
+		{`{{ first .Hosts }} {{ last .Hosts }} {{ rest .Hosts }} {{ has "db1" .Hosts }}`, "db2 db2 [db1 db2] true"},
+		{`{{ uniq .Hosts }} {{ sortAlpha .Hosts }} {{ list 1 "a" }}`, "[db2 db1] [db1 db2 db2] [1 a]"},
+		{`{{ $d := dict "a" 1 "b" 2 }}{{ get $d "b" }} {{ hasKey $d "c" }} {{ keys $d }} {{ set $d "c" 3 | keys }}`, "2 false [a b] [a b c]"},
+		{`{{ add .Port 1 }} {{ sub 10 3 }} {{ mul 6 7 }} {{ div 7 2 }} {{ mod 7 2 }} {{ max 1 9 }} {{ min 1 9 }} {{ atoi "42" }}`, "5433 7 42 3 1 9 1 42"},
+		{`{{ toJson .Config }}`, `{"ids":[0,0,7],"user":"bond"}`},
+		{`{{ toYaml .Config }}`, "ids:\n- 0\n- 0\n- 7\nuser: bond"},
+		{`{{ "bond" | b64enc }} {{ "Ym9uZA==" | b64dec }}`, "Ym9uZA== bond"},
+		{`{{ sha1sum "bond" }}`, "1d2bba5d0b80938327ac901264bcf7d4fe492fe9"},
+		{`{{ sha256sum "bond" }}`, "f21dea74d898cfeaf836ecc99ad0331bade09711ff927365e91ada2ff4cb5caf"},
+		{`{{ date "2006-01-02" .Time }} {{ unixEpoch .Time }} {{ date "2006" 1478174400 }}`, "2016-11-03 1478174400 2016"},
+		{`{{ duration "1m30s" }}`, "1m30s"},
+	}
+
+	for _, test := range tests {
+		tmpl, err := TemplateFromString(test.template)
+		if err != nil {
+			t.Fatalf("%v: %v", test.template, err)
+		}
+
+		output := &bytes.Buffer{}
+		if err := tmpl.Execute(output, data); err != nil {
+			t.Fatalf("%v: %v", test.template, err)
+		}
+		if output.String() != test.expected {
+			t.Errorf("%v: expected %q but got %q", test.template, test.expected, output.String())
+		}
+	}
+}
+
+func TestBuiltinFuncErrors(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+
+	for _, str := range []string{`{{ div 1 0 }}`, `{{ add 1.5 1 }}`, `{{ add "x" 1 }}`, `{{ dict "a" }}`, `{{ b64dec "%%" }}`, `{{ regexFind "(" "x" }}`, `{{ join "," 1 }}`} {
+		tmpl, err := TemplateFromString(str)
+		if err != nil {
+			t.Fatalf("%v: %v", str, err)
+		}
+		if err := tmpl.Execute(&bytes.Buffer{}, nil); err == nil {
+			t.Errorf("%v: expected an error", str)
+		}
+	}
+}
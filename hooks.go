@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Failure policies for hooks
+const (
+	// HookIgnore logs a failed hook and carries on
+	HookIgnore = "ignore"
+	// HookRetry runs a failed hook again up to hookRetries times before carrying on
+	HookRetry = "retry"
+	// HookAbort stops polymerase when a hook fails
+	HookAbort = "abort"
+)
+
+const (
+	hookRetries    = 3
+	hookRetryDelay = time.Second
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// Hook is a command run or a signal sent after a destination's content changes.
+// A hook without a Destination runs once whenever any destination changes.
+type Hook struct {
+	Destination string
+	Command     string
+	Signal      syscall.Signal
+	PidFile     string
+}
+
+func (h Hook) String() string {
+	if len(h.Command) > 0 {
+		return h.Command
+	}
+
+	return fmt.Sprintf("signal %v to %v", h.Signal, h.PidFile)
+}
+
+// ParseExecHook parses a hook in the form [destination=]command. The prefix
+// is only treated as a destination if it is one of destinations.
+func ParseExecHook(str string, destinations []string) (Hook, error) {
+	dest, cmd := splitHookDestination(str, destinations)
+	if len(strings.TrimSpace(cmd)) == 0 {
+		return Hook{}, fmt.Errorf("Invalid exec hook %q. Expected [destination=]command", str)
+	}
+
+	return Hook{Destination: dest, Command: cmd}, nil
+}
+
+// ParseSignalHook parses a hook in the form [destination=]SIGNAL:pidfile, e.g. HUP:/var/run/nginx.pid
+func ParseSignalHook(str string, destinations []string) (Hook, error) {
+	dest, spec := splitHookDestination(str, destinations)
+	spl := strings.SplitN(spec, ":", 2)
+	if len(spl) != 2 || len(spl[1]) == 0 {
+		return Hook{}, fmt.Errorf("Invalid signal hook %q. Expected [destination=]SIGNAL:pidfile", str)
+	}
+
+	sig, err := parseSignal(spl[0])
+	if err != nil {
+		return Hook{}, err
+	}
+
+	return Hook{Destination: dest, Signal: sig, PidFile: spl[1]}, nil
+}
+
+func splitHookDestination(str string, destinations []string) (string, string) {
+	spl := strings.SplitN(str, "=", 2)
+	if len(spl) == 2 {
+		for _, dest := range destinations {
+			if spl[0] == dest {
+				return spl[0], spl[1]
+			}
+		}
+	}
+
+	return "", str
+}
+
+// parseSignal parses a signal name such as HUP or SIGHUP, or a signal number
+func parseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+
+	if sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("Unknown signal %q", name)
+}
+
+// Run runs the hook's command through sh, killing it and anything it started
+// after timeout if that is positive, or signals the process in its pid file.
+// It returns anything the command printed.
+func (h Hook) Run(timeout time.Duration) ([]byte, error) {
+	if len(h.Command) == 0 {
+		return nil, h.signal()
+	}
+
+	out := &bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", h.Command)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-expired:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return out.Bytes(), fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+func (h Hook) signal() error {
+	contents, err := ioutil.ReadFile(h.PidFile)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return fmt.Errorf("Invalid pid in %v: %v", h.PidFile, err)
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	return p.Signal(h.Signal)
+}
+
+// HookRunner runs the hooks of destinations that changed
+type HookRunner struct {
+	Hooks      []Hook
+	Timeout    time.Duration
+	Failure    string
+	RetryDelay time.Duration
+}
+
+// newHookRunner creates a HookRunner from the configured hooks for destinations
+func newHookRunner(destinations []string) (*HookRunner, error) {
+	failure := config.HookFailure
+	switch failure {
+	case "":
+		failure = HookIgnore
+	case HookIgnore, HookRetry, HookAbort:
+	default:
+		return nil, fmt.Errorf("Unknown hook failure policy %q. Expected ignore, retry or abort", failure)
+	}
+
+	r := &HookRunner{Timeout: config.HookTimeout, Failure: failure, RetryDelay: hookRetryDelay}
+	for _, str := range config.ExecOnChange {
+		hook, err := ParseExecHook(str, destinations)
+		if err != nil {
+			return nil, err
+		}
+		r.Hooks = append(r.Hooks, hook)
+	}
+	for _, str := range config.SignalOnChange {
+		hook, err := ParseSignalHook(str, destinations)
+		if err != nil {
+			return nil, err
+		}
+		r.Hooks = append(r.Hooks, hook)
+	}
+
+	return r, nil
+}
+
+// Run runs, in order, every hook whose destination is among changed. Hook
+// output is logged. An error is only returned under the abort policy.
+func (r *HookRunner) Run(changed []RenderedFile) error {
+	if len(changed) == 0 {
+		return nil
+	}
+
+	paths := make(map[string]bool, len(changed))
+	for _, file := range changed {
+		paths[file.Path] = true
+	}
+
+	for _, hook := range r.Hooks {
+		if len(hook.Destination) > 0 && !paths[hook.Destination] {
+			continue
+		}
+
+		if err := r.run(hook); err != nil {
+			if r.Failure == HookAbort {
+				return fmt.Errorf("%v: %v", hook, err)
+			}
+			logger.Printf("Error running hook %v: %v", hook, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *HookRunner) run(hook Hook) error {
+	attempts := 1
+	if r.Failure == HookRetry {
+		attempts += hookRetries
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			logger.Printf("Hook %v failed: %v, retrying (%v/%v)", hook, err, i, hookRetries)
+			time.Sleep(r.RetryDelay)
+		}
+
+		var out []byte
+		out, err = hook.Run(r.Timeout)
+		if len(out) > 0 {
+			logger.Printf("Hook %v: %s", hook, bytes.TrimRight(out, "\n"))
+		}
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseHooks(t *testing.T) {
+	dests := []string{"/etc/nginx/nginx.conf"}
+
+	hook, err := ParseExecHook("/etc/nginx/nginx.conf=nginx -s reload", dests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Destination != "/etc/nginx/nginx.conf" || hook.Command != "nginx -s reload" {
+		t.Fatalf("Unexpected hook %#v", hook)
+	}
+
+	// A prefix that isn't a destination is part of the command
+	hook, err = ParseExecHook("RELOAD=1 ./reload.sh", dests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Destination != "" || hook.Command != "RELOAD=1 ./reload.sh" {
+		t.Fatalf("Unexpected hook %#v", hook)
+	}
+
+	hook, err = ParseSignalHook("/etc/nginx/nginx.conf=SIGHUP:/var/run/nginx.pid", dests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Destination != "/etc/nginx/nginx.conf" || hook.Signal != syscall.SIGHUP || hook.PidFile != "/var/run/nginx.pid" {
+		t.Fatalf("Unexpected hook %#v", hook)
+	}
+
+	for _, invalid := range []string{"HUP", "HUP:", "BOGUS:/var/run/app.pid"} {
+		if _, err := ParseSignalHook(invalid, dests); err == nil {
+			t.Fatalf("Signal hook %v was valid but should have been invalid", invalid)
+		}
+	}
+	if _, err := ParseExecHook("/etc/nginx/nginx.conf= ", dests); err == nil {
+		t.Fatalf("Expected an empty command to be invalid")
+	}
+}
+
+func TestHookRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "log")
+	r := &HookRunner{
+		Hooks: []Hook{
+			{Command: fmt.Sprintf("echo any >> %v", log)},
+			{Destination: "a.conf", Command: fmt.Sprintf("echo a >> %v", log)},
+			{Destination: "b.conf", Command: fmt.Sprintf("echo b >> %v", log)},
+		},
+		Failure: HookIgnore,
+	}
+
+	// Nothing runs unless something changed
+	if err := r.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		t.Fatalf("Expected no hooks to run")
+	}
+
+	if err := r.Run([]RenderedFile{{Path: "a.conf"}}); err != nil {
+		t.Fatal(err)
+	}
+	validateFile(log, "any\na\n", t)
+}
+
+func TestHookFailurePolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "log")
+	failing := Hook{Command: fmt.Sprintf("echo attempt >> %v; exit 1", log)}
+	changed := []RenderedFile{{Path: "a.conf"}}
+
+	for policy, attempts := range map[string]int{HookIgnore: 1, HookRetry: 1 + hookRetries, HookAbort: 1} {
+		os.Remove(log)
+		r := &HookRunner{Hooks: []Hook{failing}, Failure: policy, RetryDelay: time.Millisecond}
+		err := r.Run(changed)
+		if (err != nil) != (policy == HookAbort) {
+			t.Fatalf("Unexpected error %v for policy %v", err, policy)
+		}
+		validateFile(log, strings.Repeat("attempt\n", attempts), t)
+	}
+}
+
+func TestHookOutputAndTimeout(t *testing.T) {
+	out, err := Hook{Command: "echo reloaded; echo warning >&2"}.Run(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(bytes.NewBuffer(out), "reloaded\nwarning\n", t)
+
+	if _, err := (Hook{Command: "sleep 5"}).Run(50 * time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected the hook to time out but got %v", err)
+	}
+}
+
+func TestSignalHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	pidfile := writeTestFile(t, dir, "app.pid", fmt.Sprintf("%v\n", os.Getpid()))
+	if _, err := (Hook{Signal: syscall.SIGUSR1, PidFile: pidfile}).Run(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-signals:
+	case <-time.After(time.Second):
+		t.Fatal("Expected SIGUSR1")
+	}
+}
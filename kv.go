@@ -0,0 +1,45 @@
+package main
+
+import "fmt"
+
+// KV is a simple interface for a key-value store client
+type KV interface {
+	// Get returns the value of key
+	Get(key string) (string, error)
+	// Tree returns every key under prefix, relative to prefix, and its value
+	Tree(prefix string) (map[string]string, error)
+}
+
+// openKV returns *client, creating it with factory on first use so templates
+// that don't read a store never connect to it
+func openKV(client *KV, name string, factory func(Config) (KV, error)) (KV, error) {
+	if *client != nil {
+		return *client, nil
+	}
+
+	kv, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error configuring %v: %v", name, err)
+	}
+	*client = kv
+
+	return kv, nil
+}
+
+func kvGetString(open func() (KV, error), key string) (string, error) {
+	kv, err := open()
+	if err != nil {
+		return "", err
+	}
+
+	return kv.Get(key)
+}
+
+func kvGetTree(open func() (KV, error), prefix string) (map[string]string, error) {
+	kv, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	return kv.Tree(prefix)
+}
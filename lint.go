@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+var lintCmd = &cobra.Command{
+	Use:     "lint <filename>...",
+	Short:   "Check templates without rendering them",
+	Long:    "Parses templates and reports syntax errors, unknown functions and the environment variables and vault paths they reference. Vault is never contacted.",
+	Example: "polymerase lint app.env.tmpl\npolymerase lint --check-env app.env.tmpl",
+	Run:     runLint,
+}
+
+func init() {
+	lintCmd.Flags().BoolVar(&config.CheckEnv, "check-env", false, "Also report referenced environment variables that are unset.")
+	rootCmd.AddCommand(lintCmd)
+}
+
+// lintMaxPasses bounds how many undefined functions are stubbed out before lint gives up
+const lintMaxPasses = 100
+
+var (
+	templateErrorPattern = regexp.MustCompile(`^template: (.*?):(\d+):(?:(\d+):)? (.*)$`)
+	undefinedFuncPattern = regexp.MustCompile(`function "([^"]+)" not defined`)
+	quotedTokenPattern   = regexp.MustCompile(`"((?:[^"\\]|\\.)+)"`)
+)
+
+// LintError is a problem found in a template, positioned at a 1-based line and column.
+// Column is 0 when only the line is known.
+type LintError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e LintError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("%v:%v:%v: %v", e.File, e.Line, e.Column, e.Message)
+	}
+
+	return fmt.Sprintf("%v:%v: %v", e.File, e.Line, e.Message)
+}
+
+// LintResult describes a template checked by lint
+type LintResult struct {
+	File       string
+	Parsed     bool
+	Errors     []LintError
+	EnvVars    []string
+	VaultPaths []string
+	Dynamic    []string
+	Unset      []string
+}
+
+// Problems returns the number of errors and, if checked, unset environment variables
+func (r LintResult) Problems() int {
+	return len(r.Errors) + len(r.Unset)
+}
+
+func runLint(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		return
+	}
+
+	problems := 0
+	for _, filename := range args {
+		result, err := LintFile(filename, config.CheckEnv)
+		if err != nil {
+			logger.Fatalf("Error reading template: %v", err)
+		}
+
+		result.Print(config.Output)
+		problems += result.Problems()
+	}
+
+	if problems > 0 {
+		logger.Fatalf("Found %v problems", problems)
+	}
+}
+
+// LintFile parses a template without executing it. Unknown functions are
+// reported and stubbed out so the rest of the template can still be checked.
+func LintFile(filename string, checkEnv bool) (LintResult, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return LintResult{}, err
+	}
+
+	result := LintResult{File: filename}
+	str := string(contents)
+	offset := 0
+	if header := strings.SplitN(str, "\n", 2)[0]; isDirectiveHeader(header) {
+		offset = 1
+	}
+
+	tmpl, errs := lintParse(filename, str)
+	for _, err := range errs {
+		result.Errors = append(result.Errors, newLintError(filename, str, offset, err))
+	}
+	if tmpl == nil {
+		return result, nil
+	}
+	result.Parsed = true
+
+	refs := TemplateReferences(tmpl)
+	result.EnvVars, result.VaultPaths, result.Dynamic = refs.EnvVars, refs.VaultPaths, refs.Dynamic
+
+	if checkEnv {
+		for _, key := range result.EnvVars {
+			_, ok, err := lookupEnv(key)
+			if err != nil {
+				return result, err
+			}
+			if !ok {
+				result.Unset = append(result.Unset, key)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// lintParse parses str, stubbing each undefined function it meets and parsing
+// again. It returns the parsed template, if parsing eventually succeeded, and every error found.
+func lintParse(name string, str string) (*template.Template, []error) {
+	var errs []error
+	stubs := template.FuncMap{}
+	for i := 0; i < lintMaxPasses; i++ {
+		tmpl, err := parseTemplate(name, str, stubs)
+		if err == nil {
+			return tmpl, errs
+		}
+		errs = append(errs, err)
+
+		m := undefinedFuncPattern.FindStringSubmatch(err.Error())
+		if m == nil {
+			return nil, errs
+		}
+		stubs[m[1]] = func(...interface{}) string { return "" }
+	}
+
+	return nil, errs
+}
+
+// newLintError converts a text/template error into a LintError. Parse errors
+// only carry a line, so the column is found by searching that line for the
+// token the error quotes.
+func newLintError(filename string, str string, offset int, err error) LintError {
+	m := templateErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return LintError{File: filename, Message: err.Error()}
+	}
+
+	lintErr := LintError{File: m[1], Message: m[4]}
+	lintErr.Line, _ = strconv.Atoi(m[2])
+	lintErr.Column, _ = strconv.Atoi(m[3])
+	if lintErr.File != filename {
+		return lintErr
+	}
+
+	lintErr.Line += offset
+	lines := strings.Split(str, "\n")
+	if lintErr.Column == 0 && lintErr.Line > 0 && lintErr.Line <= len(lines) {
+		if token := quotedTokenPattern.FindStringSubmatch(lintErr.Message); token != nil {
+			if unquoted, err := strconv.Unquote(`"` + token[1] + `"`); err == nil {
+				lintErr.Column = strings.Index(lines[lintErr.Line-1], unquoted) + 1
+			}
+		}
+	}
+
+	return lintErr
+}
+
+// Print writes a human readable report of the result
+func (r LintResult) Print(w io.Writer) {
+	for _, err := range r.Errors {
+		fmt.Fprintln(w, err)
+	}
+
+	if !r.Parsed {
+		return
+	}
+
+	fmt.Fprintf(w, "%v:\n", r.File)
+	fmt.Fprintf(w, "  Environment variables: %v\n", listOrNone(r.EnvVars))
+	fmt.Fprintf(w, "  Vault paths: %v\n", listOrNone(r.VaultPaths))
+	if len(r.Dynamic) > 0 {
+		fmt.Fprintf(w, "  Calls with computed arguments: %v\n", strings.Join(r.Dynamic, ", "))
+	}
+	if len(r.Unset) > 0 {
+		fmt.Fprintf(w, "  Unset environment variables: %v\n", strings.Join(r.Unset, ", "))
+	}
+}
+
+func listOrNone(list []string) string {
+	if len(list) == 0 {
+		return "none"
+	}
+
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLintFile(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+	os.Setenv("FIRST_NAME", "JAMES")
+	os.Unsetenv("POLYMERASE_TEST_UNSET")
+
+	dir, err := ioutil.TempDir("", "polymerase_test_lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := writeTestFile(t, dir, "app.tmpl", "{{ .FIRST_NAME }} {{ .Env.POLYMERASE_TEST_UNSET }}\n{{ env \"HOME\" }} {{ vault \"secret/db\" }} {{ \"secret/api\" | vault }}\n{{ vault (printf \"secret/%v\" .FIRST_NAME) }}")
+	result, err := LintFile(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Errors) != 0 {
+		t.Fatalf("Expected no errors but got %v", result.Errors)
+	}
+	if expected := []string{"FIRST_NAME", "HOME", "POLYMERASE_TEST_UNSET"}; !reflect.DeepEqual(result.EnvVars, expected) {
+		t.Fatalf("Expected environment variables %v but got %v", expected, result.EnvVars)
+	}
+	if expected := []string{"secret/api", "secret/db"}; !reflect.DeepEqual(result.VaultPaths, expected) {
+		t.Fatalf("Expected vault paths %v but got %v", expected, result.VaultPaths)
+	}
+	if expected := []string{"vault"}; !reflect.DeepEqual(result.Dynamic, expected) {
+		t.Fatalf("Expected dynamic calls %v but got %v", expected, result.Dynamic)
+	}
+	if expected := []string{"POLYMERASE_TEST_UNSET"}; !reflect.DeepEqual(result.Unset, expected) {
+		t.Fatalf("Expected unset variables %v but got %v", expected, result.Unset)
+	}
+}
+
+func TestLintFileErrors(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := writeTestFile(t, dir, "app.tmpl", "ok\n  {{ shout .NAME }} {{ whisper .NAME }}")
+	result, err := LintFile(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []LintError{
+		{File: filename, Line: 2, Column: 6, Message: `function "shout" not defined`},
+		{File: filename, Line: 2, Column: 24, Message: `function "whisper" not defined`},
+	}
+	if !reflect.DeepEqual(result.Errors, expected) {
+		t.Fatalf("Expected errors %v but got %v", expected, result.Errors)
+	}
+	if !reflect.DeepEqual(result.EnvVars, []string{"NAME"}) {
+		t.Fatalf("Expected references to still be listed but got %v", result.EnvVars)
+	}
+
+	filename = writeTestFile(t, dir, "broken.tmpl", "# polymerase:delims [[ ]]\nok\n[[ if .NAME ]]")
+	result, err = LintFile(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Line != 3 {
+		t.Fatalf("Expected one error on line 3 but got %v", result.Errors)
+	}
+}
@@ -1,95 +1,398 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var vault Vault
-var logger = log.New(os.Stderr, "", log.LstdFlags)
-var config = Config{VaultFactoryFunc: AuthenticatedVaultClient, Input: os.Stdin, Output: os.Stdout}
+var consul KV
+var etcd KV
+var redactor = &Redactor{}
+var logger = &Logger{log.New(redactor.Writer(os.Stderr), "", log.LstdFlags)}
+var config = Config{VaultFactoryFunc: AuthenticatedVaultClient, ConsulFactory: NewConsulKV, EtcdFactory: NewEtcdKV, Input: os.Stdin, Output: os.Stdout}
 
 var rootCmd = &cobra.Command{
 	Use:     "polymerase",
 	Short:   "polymerase",
 	Long:    "Templates a file at the specified path using environment variables and vault values.",
-	Example: "polymerase <filename>",
-	Run:     run,
+	Example: "polymerase <filename>\n  polymerase -T nginx.conf.tmpl:/etc/nginx/nginx.conf -T app.env.tmpl:/etc/app.env",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		redactor.SetEnabled(!config.ShowSecrets)
+	},
+	Run: run,
 }
 
 func init() {
+	// The vault client logs its retries through the standard logger
+	log.SetOutput(redactor.Writer(os.Stderr))
+
 	rootCmd.PersistentFlags().StringVarP(&config.VaultAppID, "app-id", "a", os.Getenv("APP_ID"), "Vault App-ID. Can use APP_ID environment variable instead.")
 	rootCmd.PersistentFlags().StringVarP(&config.VaultAddr, "vault-addr", "v", os.Getenv("VAULT_ADDR"), "Vault server address (including protocol and port). Can use VAULT_ADDR environment variable instead.")
 	rootCmd.PersistentFlags().StringVarP(&config.VaultToken, "vault-token", "t", os.Getenv("VAULT_TOKEN"), "Vault token. Can use VAULT_TOKEN environment variable instead.")
 	rootCmd.PersistentFlags().StringVarP(&config.VaultUserIDPath, "user-id-path", "u", os.Getenv("USER_ID_PATH"), "Path to user id. Can use USER_ID_PATH environment variable instead.")
+	rootCmd.PersistentFlags().StringVar(&config.ConsulAddr, "consul-addr", os.Getenv("CONSUL_HTTP_ADDR"), "Consul address, defaulting to the local agent. Can use CONSUL_HTTP_ADDR environment variable instead.")
+	rootCmd.PersistentFlags().StringVar(&config.ConsulToken, "consul-token", os.Getenv("CONSUL_HTTP_TOKEN"), "Consul ACL token. Can use CONSUL_HTTP_TOKEN environment variable instead.")
+	rootCmd.PersistentFlags().StringSliceVar(&config.EtcdAddrs, "etcd-addr", nil, "Etcd endpoints (including protocol and port), defaulting to http://127.0.0.1:2379. May be repeated or comma separated.")
+	rootCmd.PersistentFlags().StringVar(&config.EtcdCert, "etcd-cert", "", "TLS client certificate for etcd. Requires --etcd-key.")
+	rootCmd.PersistentFlags().StringVar(&config.EtcdKey, "etcd-key", "", "TLS client key for etcd. Requires --etcd-cert.")
+	rootCmd.PersistentFlags().StringVar(&config.EtcdCA, "etcd-ca", "", "CA certificate to verify etcd servers with instead of the system roots.")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Providers, "provider", providerNames(), "Providers the secret function may read from, or name=provider to serve name:// URIs with another provider, e.g. vault=dir. May be repeated or comma separated.")
+	rootCmd.PersistentFlags().StringVar(&config.VaultFixtures, "vault-fixtures", "", "YAML, JSON, TOML or HCL file mapping vault paths to their data, read instead of connecting to vault.")
+	rootCmd.PersistentFlags().StringVar(&config.SecretsDir, "secrets-dir", "/run/secrets", "Root of the directory tree the dir provider reads secrets from.")
+	rootCmd.PersistentFlags().StringVar(&config.FileMaxMode, "file-max-mode", "0644", "Most permissive mode a secret file may have, in octal. Files allowing more fail to render.")
+	rootCmd.PersistentFlags().BoolVar(&config.FileTrimNewline, "file-trim-newline", false, "Trim trailing newlines from secret files.")
+	rootCmd.PersistentFlags().StringArrayVarP(&config.Includes, "include-dir", "I", nil, "Directory of partials (_*.tmpl) or glob of files to parse alongside every template. May be repeated.")
+	rootCmd.PersistentFlags().StringVar(&config.LeftDelim, "left-delim", "", "Left template delimiter to use instead of {{. Requires --right-delim.")
+	rootCmd.PersistentFlags().StringVar(&config.RightDelim, "right-delim", "", "Right template delimiter to use instead of }}. Requires --left-delim.")
+	rootCmd.PersistentFlags().BoolVar(&config.Strict, "strict", false, "Fail if a template references an undefined environment variable.")
+	rootCmd.PersistentFlags().StringArrayVarP(&config.DataFiles, "data", "d", nil, "YAML, JSON, TOML or HCL file exposed to templates as .Data. May be repeated; later files are deep-merged over earlier ones.")
+	rootCmd.PersistentFlags().StringArrayVarP(&config.EnvFiles, "env-file", "e", nil, "Dotenv file whose variables override the process environment. May be repeated; later files win.")
+	rootCmd.PersistentFlags().BoolVar(&config.SkipCapabilities, "skip-capability-check", false, "Don't check the vault token can read every referenced path before rendering, which costs a vault request per path.")
+	rootCmd.PersistentFlags().BoolVar(&config.ShowSecrets, "show-secrets", false, "Don't mask vault values in logs, errors and diffs. For local debugging only.")
+	rootCmd.PersistentFlags().BoolVar(&config.RevokeLeases, "revoke-leases", false, "Revoke the leases of every secret read when stopped by SIGINT or SIGTERM, or when the command run by exec exits.")
+	addRenderFlags(rootCmd.Flags())
+	rootCmd.Flags().BoolVar(&config.Diff, "diff", false, "Print a diff of the changes instead of writing them, with secrets masked. Exits 2 if anything would change.")
+}
+
+// addRenderFlags registers the flags of the commands that render templates to
+// destinations. Other commands don't have them, so they reject them.
+func addRenderFlags(flags *pflag.FlagSet) {
+	flags.StringArrayVarP(&config.Templates, "template", "T", nil, "Template to render as source:destination. May be repeated.")
+	flags.StringVarP(&config.Manifest, "manifest", "m", "", "File listing source:destination template pairs, one per line.")
+	flags.StringVarP(&config.OutputFile, "output", "o", "", "Write the rendered template to this file instead of stdout.")
+	flags.StringArrayVar(&config.ExecOnChange, "exec-on-change", nil, "Command to run through sh after a destination changes, as [destination=]command. May be repeated.")
+	flags.StringArrayVar(&config.SignalOnChange, "signal-on-change", nil, "Signal to send after a destination changes, as [destination=]SIGNAL:pidfile. May be repeated.")
+	flags.DurationVar(&config.HookTimeout, "hook-timeout", 30*time.Second, "How long an --exec-on-change command may run before it is killed.")
+	flags.StringVar(&config.HookFailure, "hook-failure", HookIgnore, "What to do when a hook fails: ignore, retry or abort.")
 }
 
 func main() {
+	// The root command renders templates, but this version of cobra rejects
+	// arguments to a command with subcommands. Detach them when the arguments
+	// don't name one so the root command gets the filenames.
+	if !namesCommand(os.Args[1:]) {
+		rootCmd.ResetCommands()
+	}
+
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v", err)
+		fmt.Fprintf(os.Stderr, "%v", redactor.Redact(err.Error()))
 		os.Exit(1)
 	}
 }
 
+// namesCommand reports whether args run a subcommand. Cobra only adds its help
+// command when executing, so a stand-in is added while looking args up.
+func namesCommand(args []string) bool {
+	help := &cobra.Command{Use: "help [command]"}
+	rootCmd.AddCommand(help)
+	defer rootCmd.RemoveCommand(help)
+
+	cmd, _, err := rootCmd.Find(args)
+	return err == nil || cmd != rootCmd
+}
+
 func run(cmd *cobra.Command, args []string) {
 
-	if len(args) > 1 {
+	pairs, err := templatePairs()
+	if err != nil {
+		logger.Fatalf("Error reading template pairs: %v", err)
+	}
+
+	if len(args) > 1 || (len(args) > 0 && len(pairs) > 0) || (len(pairs) > 0 && len(config.OutputFile) > 0) {
 		cmd.Usage()
 		return
 	}
 
+	if config.Diff && len(pairs) == 0 && len(config.OutputFile) == 0 {
+		logger.Fatalf("Error: --diff requires --output or --template")
+	}
+
+	hooks, err := newHookRunner(destinations(pairs))
+	if err != nil {
+		logger.Fatalf("Error reading hooks: %v", err)
+	}
+
 	if _, err := config.Validate(); err != nil {
 		logger.Fatalf("Error validating config: %v", err)
 	}
 
-	var err error
-	vault, err = config.VaultFactoryFunc(config)
+	handleShutdown()
+	configureVault()
+
+	data, err := templateContext()
 	if err != nil {
-		logger.Fatalf("Error configuring vault: %v", err)
+		logger.Fatalf("Error loading data: %v", err)
 	}
 
-	var tmpl Template
-	if len(args) == 1 {
-		tmpl, err = TemplateFromFile(args[0])
+	var files []RenderedFile
+	if len(pairs) > 0 {
+		outputs, err := RenderPairs(pairs, data)
+		if err != nil {
+			logger.Fatalf("Error populating template: %v", err)
+		}
+		files = PairFiles(pairs, outputs)
+		if config.Diff {
+			if err := markSecretFiles(pairs, files); err != nil {
+				logger.Fatalf("Error comparing template: %v", err)
+			}
+		}
 	} else {
-		tmpl, err = TemplateFromReader(config.Input)
+		var tmpl Template
+		if len(args) == 1 {
+			tmpl, err = TemplateFromFile(args[0])
+		} else {
+			tmpl, err = TemplateFromReader(config.Input)
+		}
+
+		if err != nil {
+			logger.Fatalf("Error parsing template: %v", err)
+		}
+
+		if len(config.OutputFile) == 0 {
+			if err := tmpl.Execute(config.Output, data); err != nil {
+				logger.Fatalf("Error populating template: %v", err)
+			}
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, data); err != nil {
+			logger.Fatalf("Error populating template: %v", err)
+		}
+		files = []RenderedFile{{Path: config.OutputFile, Data: buf.Bytes(), Mode: destinationMode(config.OutputFile, 0644), Secret: ReadsSecrets(tmpl)}}
+	}
+
+	if config.Diff {
+		changed, err := printDiffs(files)
+		if err != nil {
+			logger.Fatalf("Error comparing template: %v", err)
+		}
+		if changed {
+			exit(ExitChanged)
+		}
+		return
+	}
+
+	changed := ChangedFiles(files)
+	if err := WriteFiles(files); err != nil {
+		logger.Fatalf("Error writing template: %v", err)
+	}
+
+	if err := hooks.Run(changed); err != nil {
+		logger.Fatalf("Error running hook: %v", err)
+	}
+}
+
+// destinations returns the files that pairs, or else --output, are rendered to
+func destinations(pairs []TemplatePair) []string {
+	var dests []string
+	for _, pair := range pairs {
+		dests = append(dests, pair.Destination)
+	}
+	if len(config.OutputFile) > 0 {
+		dests = append(dests, config.OutputFile)
+	}
+
+	return dests
+}
+
+// printDiffs writes a diff for every file that would change and reports whether any would
+func printDiffs(files []RenderedFile) (bool, error) {
+	changed := false
+	for _, file := range files {
+		diff, err := DiffFile(file, redactor)
+		if err != nil {
+			return false, err
+		}
+
+		if len(diff) > 0 {
+			changed = true
+			fmt.Fprint(config.Output, diff)
+		}
+	}
+
+	return changed, nil
+}
+
+// configureVault connects to vault. With --vault-fixtures secrets are read
+// from the fixtures file instead, and if --provider leaves vault out or serves
+// vault:// URIs with another provider, vault paths are read through it.
+func configureVault() {
+	if !config.Offline() {
+		vault = newCachingVault(authenticatedVault())
+		return
+	}
+
+	if len(config.VaultFixtures) == 0 {
+		vault = newCachingVault(providerVault{})
+		return
 	}
 
+	fixtures, err := LoadVaultFixtures(config.VaultFixtures)
 	if err != nil {
-		logger.Fatalf("Error parsing template: %v", err)
+		logger.Fatalf("Error loading vault fixtures: %v", err)
 	}
+	vault = newCachingVault(fixtures)
+}
 
-	err = tmpl.Execute(config.Output, env())
+// authenticatedVault creates the vault client. The config must already be validated.
+func authenticatedVault() Vault {
+	v, err := config.VaultFactoryFunc(config)
 	if err != nil {
-		logger.Fatalf("Error populating template: %v", err)
+		logger.Fatalf("Error configuring vault: %v", err)
+	}
+
+	return v
+}
+
+func templatePairs() ([]TemplatePair, error) {
+	var pairs []TemplatePair
+	for _, str := range config.Templates {
+		pair, err := ParseTemplatePair(str)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
 	}
+
+	if len(config.Manifest) > 0 {
+		manifestPairs, err := TemplatePairsFromManifest(config.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, manifestPairs...)
+	}
+
+	return pairs, nil
+}
+
+// loadedEnv is the environment of the current render. It is loaded once per
+// render so lookups don't re-read every --env-file.
+var loadedEnv struct {
+	sync.Mutex
+	env map[string]string
 }
 
-func env() map[string]string {
+// loadEnv reads the process environment with any --env-file variables layered
+// over it and keeps it for the lookups of the render that follows
+func loadEnv() (map[string]string, error) {
 	env := make(map[string]string)
 	for _, item := range os.Environ() {
-		key, val := envKeyVal(item)
-		env[key] = val
+		if key, val, ok := envKeyVal(item); ok {
+			env[key] = val
+		}
+	}
+
+	if err := LoadEnvFiles(config.EnvFiles, env); err != nil {
+		return nil, fmt.Errorf("Error loading env file: %v", err)
+	}
+
+	loadedEnv.Lock()
+	loadedEnv.env = env
+	loadedEnv.Unlock()
+
+	return copyEnv(env), nil
+}
+
+// env returns a copy of the environment loaded for the current render,
+// loading it if nothing has been rendered yet
+func env() (map[string]string, error) {
+	loadedEnv.Lock()
+	loaded := loadedEnv.env
+	loadedEnv.Unlock()
+	if loaded == nil {
+		return loadEnv()
+	}
+
+	return copyEnv(loaded), nil
+}
+
+func copyEnv(env map[string]string) map[string]string {
+	cp := make(map[string]string, len(env))
+	for key, val := range env {
+		cp[key] = val
 	}
 
-	return env
+	return cp
 }
 
-func envKeyVal(env string) (string, string) {
-	spl := strings.Split(env, "=")
+// envKeyVal splits a KEY=VALUE environment entry. Entries without a key,
+// including the =C:=C:\ drive entries Windows keeps, are rejected.
+func envKeyVal(env string) (string, string, bool) {
+	i := strings.Index(env, "=")
+	if i <= 0 {
+		return "", "", false
+	}
 
-	return spl[0], strings.Join(spl[1:], "=")
+	return env[:i], env[i+1:], true
 }
 
-func vaultGetString(path string) string {
+func vaultGetString(path string) (string, error) {
 	val, err := vault.GetStringValue(path)
 	if err != nil {
-		logger.Fatalf("Error fetching value from vault: %v", err)
+		return "", fmt.Errorf("Error fetching value from vault: %v", err)
+	}
+	redactor.Add(val)
+
+	return val, nil
+}
+
+func lookupEnv(key string) (string, bool, error) {
+	loadedEnv.Lock()
+	loaded := loadedEnv.env
+	loadedEnv.Unlock()
+	if loaded == nil {
+		var err error
+		if loaded, err = loadEnv(); err != nil {
+			return "", false, err
+		}
+	}
+
+	val, ok := loaded[key]
+	return val, ok, nil
+}
+
+func envGetString(key string, def ...string) (string, error) {
+	if len(def) > 1 {
+		return "", fmt.Errorf("env accepts at most one default, got %v", len(def))
+	}
+
+	val, ok, err := lookupEnv(key)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return val, nil
+	}
+	if len(def) == 1 {
+		return def[0], nil
+	}
+
+	return "", nil
+}
+
+func hasEnv(key string) (bool, error) {
+	_, ok, err := lookupEnv(key)
+	return ok, err
+}
+
+func requiredEnvGetString(key string) (string, error) {
+	val, ok, err := lookupEnv(key)
+	if err != nil {
+		return "", err
+	}
+	if ok && len(val) > 0 {
+		return val, nil
 	}
 
-	return val
+	return "", fmt.Errorf("Required environment variable %v is not set", key)
 }
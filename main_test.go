@@ -5,7 +5,11 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/spf13/cobra"
 )
 
 func TestEnv(t *testing.T) {
@@ -29,6 +33,30 @@ func TestVault(t *testing.T) {
 	validateOutput(output, "BOND", t)
 }
 
+func TestContext(t *testing.T) {
+	template := "{{ .FIRST_NAME }} {{ .Env.FIRST_NAME }} {{ hasEnv \"FIRST_NAME\" }} {{ hasEnv \"POLYMERASE_TEST_UNSET\" }} {{ .Vault.Addr }} {{ .Vault.AuthMethod }} {{ not .Meta.Time.IsZero }}"
+	output := &bytes.Buffer{}
+	context := newTestContext("", template, output)
+	setupTest(context)
+
+	os.Setenv("FIRST_NAME", "JAMES")
+	os.Unsetenv("POLYMERASE_TEST_UNSET")
+	run(rootCmd, []string{})
+	validateOutput(output, "JAMES JAMES true false ADDR token true", t)
+}
+
+func TestEnvKeyVal(t *testing.T) {
+	if key, val, ok := envKeyVal("URL=http://host/?a=b"); !ok || key != "URL" || val != "http://host/?a=b" {
+		t.Fatalf("Unexpected key %q and value %q", key, val)
+	}
+
+	for _, invalid := range []string{"=C:=C:\\Windows", "=value", "NOVALUE"} {
+		if _, _, ok := envKeyVal(invalid); ok {
+			t.Fatalf("Environment entry %q was valid but should have been invalid", invalid)
+		}
+	}
+}
+
 func TestFile(t *testing.T) {
 	output := &bytes.Buffer{}
 	context := newTestContext("BOND", "", output)
@@ -53,6 +81,68 @@ func TestFile(t *testing.T) {
 	validateOutput(output, "JAMES BOND", t)
 }
 
+func TestTemplatePairs(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	os.Setenv("FIRST_NAME", "JAMES")
+
+	dir, err := ioutil.TempDir("", "polymerase_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := writeTestFile(t, dir, "first.tmpl", "{{ .FIRST_NAME }}")
+	last := writeTestFile(t, dir, "last.tmpl", "{{ vault \"secret_agents/007/last_name\" }}")
+	config.Templates = []string{first + ":" + filepath.Join(dir, "first"), last + ":" + filepath.Join(dir, "last")}
+
+	run(rootCmd, []string{})
+	validateFile(filepath.Join(dir, "first"), "JAMES", t)
+	validateFile(filepath.Join(dir, "last"), "BOND", t)
+}
+
+func TestTemplatePairsAllOrNothing(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := writeTestFile(t, dir, "good.tmpl", "{{ vault \"secret_agents/007/last_name\" }}")
+	bad := writeTestFile(t, dir, "bad.tmpl", "{{ .Missing.Field }}")
+	goodDst := writeTestFile(t, dir, "good", "OLD")
+	pairs := []TemplatePair{{Source: good, Destination: goodDst}, {Source: bad, Destination: filepath.Join(dir, "bad")}}
+	vault = context.mockVault
+
+	if _, err := RenderPairs(pairs, map[string]interface{}{"Missing": 1}); err == nil {
+		t.Fatalf("Expected rendering %v to fail", bad)
+	}
+	validateFile(goodDst, "OLD", t)
+	if _, err := os.Stat(filepath.Join(dir, "bad")); !os.IsNotExist(err) {
+		t.Fatalf("Expected %v not to be written", filepath.Join(dir, "bad"))
+	}
+}
+
+func writeTestFile(t *testing.T, dir string, name string, contents string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func validateFile(filename string, expected string, t *testing.T) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(bytes.NewBuffer(contents), expected, t)
+}
+
 func validateOutput(actual *bytes.Buffer, expected string, t *testing.T) {
 	outStr := string(actual.Bytes())
 	if outStr != expected {
@@ -62,6 +152,10 @@ func validateOutput(actual *bytes.Buffer, expected string, t *testing.T) {
 
 func setupTest(context *testContext) {
 	config = newTestConfig(context.mockVault.Vault, context.inputBuffer, context.outputBuffer)
+	consul = nil
+	providers = newProviderRegistry()
+	etcd = nil
+	loadedEnv.env = nil
 }
 
 type testContext struct {
@@ -92,3 +186,55 @@ func (c mockVaultClient) GetStringValue(path string) (string, error) {
 func (c mockVaultClient) Vault(config Config) (Vault, error) {
 	return c, nil
 }
+
+func TestNamesCommand(t *testing.T) {
+	for args, expected := range map[string]bool{
+		"lint a.tmpl":                true,
+		"help":                       true,
+		"help lint":                  true,
+		"--strict deps a.tmpl":       true,
+		"nginx.conf.tmpl":            false,
+		"--strict nginx.conf.tmpl":   false,
+		"nginx.conf.tmpl extra.tmpl": false,
+	} {
+		if named := namesCommand(strings.Fields(args)); named != expected {
+			t.Fatalf("Expected %q naming a command to be %v", args, expected)
+		}
+	}
+
+	// help still describes subcommands
+	output := &bytes.Buffer{}
+	rootCmd.SetOutput(output)
+	defer rootCmd.SetOutput(nil)
+	rootCmd.SetArgs([]string{"help", "lint"})
+	defer rootCmd.SetArgs(nil)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "lint <filename>...") {
+		t.Fatalf("Expected the lint usage but got %v", output.String())
+	}
+}
+
+func TestRenderFlags(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	renderFlags := []string{"--output", "--template", "--manifest", "--exec-on-change", "--signal-on-change"}
+	for _, flag := range renderFlags {
+		if err := watchCmd.ParseFlags([]string{flag, "x"}); err != nil {
+			t.Fatalf("Expected watch to accept %v but got %v", flag, err)
+		}
+	}
+
+	// Commands that don't write destinations reject the flags instead of ignoring them
+	for _, cmd := range []*cobra.Command{renderDirCmd, lintCmd, depsCmd, execCmd} {
+		for _, flag := range append(renderFlags, "--diff") {
+			if err := cmd.ParseFlags([]string{flag, "x"}); err == nil {
+				t.Fatalf("Expected %v to reject %v", cmd.Name(), flag)
+			}
+		}
+	}
+	if err := watchCmd.ParseFlags([]string{"--diff"}); err == nil {
+		t.Fatalf("Expected watch to reject --diff")
+	}
+}
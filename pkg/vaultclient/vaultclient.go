@@ -3,18 +3,24 @@ package vaultclient
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/vault/api"
 )
 
-const (
-	authretries       = 10
-	retrydelayseconds = 3
-)
+const authretries = 10
+
+// ErrCapabilitiesUnsupported is returned by CapabilitiesSelf when the vault
+// server can't report the capabilities of a token
+var ErrCapabilitiesUnsupported = errors.New("vault server can't report capabilities")
+
+// retrydelay is how long to wait between auth attempts. Tests shorten it.
+var retrydelay = 3 * time.Second
 
 type VaultConfig struct {
 	Server string // protocol, hostname and port (https://vault.foo.com:8200)
@@ -24,6 +30,10 @@ type VaultClient struct {
 	client *api.Client
 	config *VaultConfig
 	token  string
+	mu     sync.Mutex
+	leases []string
+	// durations holds the lease duration of each secret as of its last read
+	durations map[string]time.Duration
 }
 
 // NewClient returns a VaultClient object or error
@@ -47,7 +57,7 @@ func (c *VaultClient) TokenAuth(token string) error {
 			break
 		}
 		log.Printf("Token auth failed: %v, retrying (%v/%v)", err, i+1, authretries)
-		time.Sleep(retrydelayseconds * time.Second)
+		time.Sleep(retrydelay)
 	}
 	if err != nil {
 		return fmt.Errorf("error performing auth call to Vault (retries exceeded): %v", err)
@@ -92,7 +102,7 @@ func (c *VaultClient) AppIDAuth(appid string, useridpath string) error {
 			break
 		}
 		log.Printf("App-ID auth failed: %v, retrying (%v/%v)", err, i+1, authretries)
-		time.Sleep(retrydelayseconds * time.Second)
+		time.Sleep(retrydelay)
 	}
 	if err != nil {
 		return fmt.Errorf("error performing auth call to Vault (retries exceeded): %v", err)
@@ -112,6 +122,11 @@ func (c *VaultClient) AppIDAuth(appid string, useridpath string) error {
 
 // GetValue retrieves value at path
 func (c *VaultClient) GetValue(path string) (interface{}, error) {
+	return c.GetField(path, "value")
+}
+
+// GetField retrieves the field named key of the secret at path
+func (c *VaultClient) GetField(path string, key string) (interface{}, error) {
 	c.client.SetToken(c.token)
 	lc := c.client.Logical()
 	s, err := lc.Read(path)
@@ -121,15 +136,22 @@ func (c *VaultClient) GetValue(path string) (interface{}, error) {
 	if s == nil {
 		return nil, fmt.Errorf("secret not found")
 	}
-	if _, ok := s.Data["value"]; !ok {
-		return nil, fmt.Errorf("secret missing 'value' key")
+	c.addLease(s.LeaseID)
+	c.setDuration(path, time.Duration(s.LeaseDuration)*time.Second)
+	if _, ok := s.Data[key]; !ok {
+		return nil, fmt.Errorf("secret missing '%v' key", key)
 	}
-	return s.Data["value"], nil
+	return s.Data[key], nil
 }
 
 // GetStringValue retrieves a value expected to be a string
 func (c *VaultClient) GetStringValue(path string) (string, error) {
-	val, err := c.GetValue(path)
+	return c.GetStringField(path, "value")
+}
+
+// GetStringField retrieves the field named key of the secret at path, expected to be a string
+func (c *VaultClient) GetStringField(path string, key string) (string, error) {
+	val, err := c.GetField(path, key)
 	if err != nil {
 		return "", err
 	}
@@ -137,8 +159,29 @@ func (c *VaultClient) GetStringValue(path string) (string, error) {
 	case string:
 		return val, nil
 	default:
-		return "", fmt.Errorf("unexpected type for %v value: %T", path, val)
+		return "", fmt.Errorf("unexpected type for %v %v: %T", path, key, val)
+	}
+}
+
+// ListKeys returns the keys under path
+func (c *VaultClient) ListKeys(path string) ([]string, error) {
+	c.client.SetToken(c.token)
+	s, err := c.client.Logical().List(path)
+	if err != nil {
+		return nil, fmt.Errorf("error listing secrets from Vault: %v: %v", path, err)
+	}
+	if s == nil {
+		return nil, fmt.Errorf("secret not found")
+	}
+	list, ok := s.Data["keys"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected type for %v keys: %T", path, s.Data["keys"])
 	}
+	keys := make([]string, 0, len(list))
+	for _, key := range list {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	return keys, nil
 }
 
 // GetBase64Value retrieves and decodes a value expected to be base64-encoded binary
@@ -161,3 +204,96 @@ func (c *VaultClient) WriteValue(path string, data []byte) error {
 	_, err := lc.Write(path, map[string]interface{}{"value": data})
 	return err
 }
+
+// CapabilitiesSelf returns the capabilities the client's token has on path.
+// It returns ErrCapabilitiesUnsupported if the server has no endpoint for them.
+func (c *VaultClient) CapabilitiesSelf(path string) ([]string, error) {
+	c.client.SetToken(c.token)
+	r := c.client.NewRequest("POST", "/v1/sys/capabilities-self")
+	if err := r.SetJSONBody(map[string]string{"path": path}); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == 404 || resp.StatusCode == 405 {
+			return nil, ErrCapabilitiesUnsupported
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking capabilities on %v: %v", path, err)
+	}
+
+	var result struct {
+		Capabilities []string `json:"capabilities"`
+	}
+	if err := resp.DecodeJSON(&result); err != nil {
+		return nil, fmt.Errorf("error checking capabilities on %v: %v", path, err)
+	}
+	return result.Capabilities, nil
+}
+
+// PutPolicy creates or replaces the ACL policy name with rules
+func (c *VaultClient) PutPolicy(name string, rules string) error {
+	c.client.SetToken(c.token)
+	return c.client.Sys().PutPolicy(name, rules)
+}
+
+// LeaseDuration returns the lease duration recorded by the last read of the
+// secret at path, so a dynamic secret isn't read again and issued another
+// credential. Secrets that haven't been read yet are read now.
+func (c *VaultClient) LeaseDuration(path string) (time.Duration, error) {
+	c.mu.Lock()
+	lease, ok := c.durations[path]
+	c.mu.Unlock()
+	if ok {
+		return lease, nil
+	}
+
+	c.client.SetToken(c.token)
+	lc := c.client.Logical()
+	s, err := lc.Read(path)
+	if err != nil {
+		return 0, fmt.Errorf("error reading secret from Vault: %v: %v", path, err)
+	}
+	if s == nil {
+		return 0, fmt.Errorf("secret not found")
+	}
+	c.addLease(s.LeaseID)
+	lease = time.Duration(s.LeaseDuration) * time.Second
+	c.setDuration(path, lease)
+	return lease, nil
+}
+
+// RevokeLeases revokes the leases of every secret read so far
+func (c *VaultClient) RevokeLeases() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client.SetToken(c.token)
+	for len(c.leases) > 0 {
+		if err := c.client.Sys().Revoke(c.leases[0]); err != nil {
+			return fmt.Errorf("error revoking lease %v: %v", c.leases[0], err)
+		}
+		c.leases = c.leases[1:]
+	}
+	return nil
+}
+
+func (c *VaultClient) setDuration(path string, lease time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.durations == nil {
+		c.durations = make(map[string]time.Duration)
+	}
+	c.durations[path] = lease
+}
+
+func (c *VaultClient) addLease(id string) {
+	if len(id) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leases = append(c.leases, id)
+}
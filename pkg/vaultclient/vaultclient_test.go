@@ -1,9 +1,16 @@
 package vaultclient
 
 import (
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/polymerase/pkg/vaulttest"
 )
 
 const (
@@ -66,3 +73,197 @@ func TestVaultGetValue(t *testing.T) {
 	}
 	log.Printf("Got value: %v", d.(string))
 }
+
+// shortenRetryDelay makes auth retries immediate and returns a func restoring the delay
+func shortenRetryDelay() func() {
+	delay := retrydelay
+	retrydelay = time.Millisecond
+	return func() { retrydelay = delay }
+}
+
+func newTestServer(t *testing.T) (*vaulttest.Server, *VaultClient) {
+	server := vaulttest.NewServer()
+	vc, err := NewClient(&VaultConfig{Server: server.URL})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	return server, vc
+}
+
+func TestTokenAuthServer(t *testing.T) {
+	defer shortenRetryDelay()()
+	server, vc := newTestServer(t)
+	defer server.Close()
+
+	// Transient failures are retried
+	server.Fail("auth/token/lookup-self", http.StatusServiceUnavailable, 2)
+	if err := vc.TokenAuth(vaulttest.RootToken); err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+	if n := len(server.Requests()); n != 3 {
+		t.Fatalf("Expected 3 lookups but got %v", n)
+	}
+
+	if err := vc.TokenAuth("invalid"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("Expected an invalid token to fail but got %v", err)
+	}
+}
+
+func TestAppIDAuthServer(t *testing.T) {
+	defer shortenRetryDelay()()
+	server, vc := newTestServer(t)
+	defer server.Close()
+	server.AddAppID("testing-development", "178ae890-4ee1-422d-9877-ed1e784c6adf")
+	server.Write("secret/testing/test_value", map[string]interface{}{"value": "app-id-value"})
+
+	dir, err := ioutil.TempDir("", "vaultclient_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userID := filepath.Join(dir, "user-id")
+	if err := ioutil.WriteFile(userID, []byte("178ae890-4ee1-422d-9877-ed1e784c6adf"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vc.AppIDAuth("testing-development", userID); err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+	val, err := vc.GetStringValue(testSecretPath)
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if val != "app-id-value" {
+		t.Fatalf("Expected app-id-value but got %v", val)
+	}
+
+	if err := vc.AppIDAuth("unknown", userID); err == nil || !strings.Contains(err.Error(), "invalid user ID or app ID") {
+		t.Fatalf("Expected an unknown app ID to fail but got %v", err)
+	}
+}
+
+func TestGetValueServer(t *testing.T) {
+	defer shortenRetryDelay()()
+	server, vc := newTestServer(t)
+	defer server.Close()
+	if err := vc.TokenAuth(vaulttest.RootToken); err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+
+	server.Write("secret/db", map[string]interface{}{"value": "s3cr3t", "username": "app", "port": 5432})
+	// A secret whose own keys include data and metadata is read as it is
+	server.Write("secret/nested", map[string]interface{}{"value": "nested-value", "data": map[string]interface{}{"value": "inner"}, "metadata": "meta"})
+	server.EnableKV2("kv")
+	server.Write("kv/app/api", map[string]interface{}{"value": "v2-value"})
+	server.Write("kv/app/db", map[string]interface{}{"value": "v2-db"})
+
+	for path, expected := range map[string]string{"secret/db": "s3cr3t", "secret/nested": "nested-value"} {
+		val, err := vc.GetStringValue(path)
+		if err != nil {
+			t.Fatalf("Error getting %v: %v", path, err)
+		}
+		if val != expected {
+			t.Fatalf("Expected %v for %v but got %v", expected, path, val)
+		}
+	}
+
+	if val, err := vc.GetStringField("secret/db", "username"); err != nil || val != "app" {
+		t.Fatalf("Expected app but got %v, %v", val, err)
+	}
+
+	for field, expected := range map[string]string{"port": "unexpected type for secret/db port", "password": "secret missing 'password' key"} {
+		if _, err := vc.GetStringField("secret/db", field); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %q but got %v", expected, err)
+		}
+	}
+	if _, err := vc.GetStringValue("secret/missing"); err == nil || err.Error() != "secret not found" {
+		t.Fatalf("Expected a missing secret to fail but got %v", err)
+	}
+
+	keys, err := vc.ListKeys("kv/metadata/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "api,db" {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+
+	server.Fail("secret/db", http.StatusInternalServerError, 0)
+	if _, err := vc.GetStringValue("secret/db"); err == nil || !strings.Contains(err.Error(), "injected failure") {
+		t.Fatalf("Expected an injected failure but got %v", err)
+	}
+}
+
+func TestWriteServer(t *testing.T) {
+	defer shortenRetryDelay()()
+	server, vc := newTestServer(t)
+	defer server.Close()
+	if err := vc.TokenAuth(vaulttest.RootToken); err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+
+	if err := vc.WriteValue("secret/binary", []byte("binary-data")); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := vc.GetBase64Value("secret/binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "binary-data" {
+		t.Fatalf("Expected binary-data but got %s", decoded)
+	}
+
+	if err := vc.PutPolicy("app", `path "secret/*" { policy = "read" }`); err != nil {
+		t.Fatal(err)
+	}
+	if rules := server.Policy("app"); rules != `path "secret/*" { policy = "read" }` {
+		t.Fatalf("Unexpected policy %v", rules)
+	}
+
+	server.SetCapabilities("secret/denied", "deny")
+	for path, expected := range map[string]string{"secret/binary": "read", "secret/denied": "deny"} {
+		caps, err := vc.CapabilitiesSelf(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(caps, ",") != expected {
+			t.Fatalf("Expected %v on %v but got %v", expected, path, caps)
+		}
+	}
+}
+
+func TestLeasesServer(t *testing.T) {
+	defer shortenRetryDelay()()
+	server, vc := newTestServer(t)
+	defer server.Close()
+	if err := vc.TokenAuth(vaulttest.RootToken); err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+	server.WriteSecret("database/creds/app", vaulttest.Secret{Data: map[string]interface{}{"value": "leased"}, LeaseDuration: time.Hour, Renewable: true})
+
+	if _, err := vc.GetStringValue("database/creds/app"); err != nil {
+		t.Fatal(err)
+	}
+	lease, err := vc.LeaseDuration("database/creds/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease != time.Hour {
+		t.Fatalf("Expected a lease of 1h but got %v", lease)
+	}
+
+	// The lease comes from the read that produced the value, not another credential
+	leases := server.Leases()
+	if len(leases) != 1 {
+		t.Fatalf("Expected 1 lease but got %v", leases)
+	}
+	if err := vc.RevokeLeases(); err != nil {
+		t.Fatal(err)
+	}
+	if revoked := server.Revoked(); strings.Join(revoked, ",") != strings.Join(leases, ",") {
+		t.Fatalf("Expected %v to be revoked but got %v", leases, revoked)
+	}
+	if remaining := server.Leases(); len(remaining) != 0 {
+		t.Fatalf("Expected every lease to be revoked but %v remain", remaining)
+	}
+}
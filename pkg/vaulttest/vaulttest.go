@@ -0,0 +1,449 @@
+// Package vaulttest runs an in-process fake of the Vault HTTP API so vault
+// clients can be tested over real HTTP without a Vault server.
+package vaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RootToken is accepted by every new server
+const RootToken = "root"
+
+// Secret is a secret stored in the fake server
+type Secret struct {
+	Data          map[string]interface{}
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Server is a fake Vault server. It implements token lookup-self, app-id and
+// approle login, logical reads, writes, lists and deletes, KV version 2
+// mounts, lease revocation, capabilities and policies. Close it when done.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	tokens       map[string]bool
+	appIDs       map[string]string
+	appRoles     map[string]string
+	secrets      map[string]Secret
+	versions     map[string]int
+	kv2          map[string]bool
+	capabilities map[string][]string
+	policies     map[string]string
+	leases       map[string]string
+	revoked      []string
+	requests     []string
+	failures     []*failure
+	latency      time.Duration
+	nextID       int
+}
+
+// failure is an injected failure. A negative remaining fails forever.
+type failure struct {
+	prefix    string
+	status    int
+	remaining int
+}
+
+// NewServer starts a fake Vault server that accepts RootToken
+func NewServer() *Server {
+	s := &Server{
+		tokens:       map[string]bool{RootToken: true},
+		appIDs:       make(map[string]string),
+		appRoles:     make(map[string]string),
+		secrets:      make(map[string]Secret),
+		versions:     make(map[string]int),
+		kv2:          make(map[string]bool),
+		capabilities: make(map[string][]string),
+		policies:     make(map[string]string),
+		leases:       make(map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// AddToken makes token valid
+func (s *Server) AddToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = true
+}
+
+// AddAppID allows app-id login with appID and userID
+func (s *Server) AddAppID(appID string, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appIDs[appID] = userID
+}
+
+// AddAppRole allows approle login with roleID and secretID
+func (s *Server) AddAppRole(roleID string, secretID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appRoles[roleID] = secretID
+}
+
+// EnableKV2 serves the secrets under mount with the KV version 2 API, at
+// mount/data/path and mount/metadata/path
+func (s *Server) EnableKV2(mount string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv2[strings.Trim(mount, "/")] = true
+}
+
+// Write stores data at path. Under a KV version 2 mount, path excludes the data/ segment.
+func (s *Server) Write(path string, data map[string]interface{}) {
+	s.WriteSecret(path, Secret{Data: data})
+}
+
+// WriteSecret stores secret at path. Secrets with a lease duration get a new lease on every read.
+func (s *Server) WriteSecret(path string, secret Secret) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(strings.Trim(path, "/"), secret)
+}
+
+// Read returns the data stored at path
+func (s *Server) Read(path string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[strings.Trim(path, "/")]
+	return secret.Data, ok
+}
+
+// SetCapabilities sets the capabilities every token has on path. Paths
+// without capabilities set report read.
+func (s *Server) SetCapabilities(path string, capabilities ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capabilities[path] = capabilities
+}
+
+// Policy returns the rules of the policy name, or an empty string if it hasn't been written
+func (s *Server) Policy(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policies[name]
+}
+
+// Leases returns the IDs of the leases that haven't been revoked
+func (s *Server) Leases() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id := range s.leases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Revoked returns the IDs of the leases revoked so far, in order
+func (s *Server) Revoked() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.revoked...)
+}
+
+// Requests returns every request received so far as "METHOD path", without the /v1/ prefix
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Fail makes the next times requests whose path starts with prefix fail with
+// status. Every matching request fails if times is not positive.
+func (s *Server) Fail(prefix string, status int, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if times <= 0 {
+		times = -1
+	}
+	s.failures = append(s.failures, &failure{prefix: strings.Trim(prefix, "/"), status: status, remaining: times})
+}
+
+// SetLatency delays every response by latency
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	time.Sleep(latency)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	method := r.Method
+	if method == "GET" && r.URL.Query().Get("list") == "true" {
+		method = "LIST"
+	}
+	s.requests = append(s.requests, method+" "+path)
+
+	if status, ok := s.injectedFailure(path); ok {
+		writeErrors(w, status, "injected failure")
+		return
+	}
+
+	switch path {
+	case "auth/app-id/login":
+		s.login(w, r, "app_id", "user_id", s.appIDs, "invalid user ID or app ID")
+		return
+	case "auth/approle/login":
+		s.login(w, r, "role_id", "secret_id", s.appRoles, "invalid role ID or secret ID")
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if !s.tokens[token] {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case path == "auth/token/lookup-self":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"id": token, "policies": []string{"default"}, "ttl": 0},
+		})
+	case path == "sys/capabilities-self":
+		s.capabilitiesSelf(w, r)
+	case strings.HasPrefix(path, "sys/policy/"):
+		s.policy(w, r, strings.TrimPrefix(path, "sys/policy/"))
+	case strings.HasPrefix(path, "sys/revoke/"):
+		s.revoke(w, strings.TrimPrefix(path, "sys/revoke/"))
+	default:
+		s.logical(w, r, method, path)
+	}
+}
+
+func (s *Server) injectedFailure(path string) (int, bool) {
+	for _, f := range s.failures {
+		if f.remaining == 0 || !strings.HasPrefix(path, f.prefix) {
+			continue
+		}
+		if f.remaining > 0 {
+			f.remaining--
+		}
+		return f.status, true
+	}
+
+	return 0, false
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request, idKey string, secretKey string, credentials map[string]string, invalid string) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	expected, ok := credentials[body[idKey]]
+	if !ok || len(body[idKey]) == 0 || body[secretKey] != expected {
+		writeErrors(w, http.StatusBadRequest, invalid)
+		return
+	}
+
+	token := s.newID("token")
+	s.tokens[token] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   token,
+			"policies":       []string{"default"},
+			"lease_duration": 3600,
+			"renewable":      true,
+		},
+	})
+}
+
+func (s *Server) capabilitiesSelf(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	capabilities, ok := s.capabilities[body["path"]]
+	if !ok {
+		capabilities = []string{"read"}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"capabilities": capabilities})
+}
+
+func (s *Server) policy(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case "GET":
+		rules, ok := s.policies[name]
+		if !ok {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": name, "rules": rules})
+	case "PUT", "POST":
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.policies[name] = body["rules"]
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.policies, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) revoke(w http.ResponseWriter, id string) {
+	if _, ok := s.leases[id]; !ok {
+		writeErrors(w, http.StatusBadRequest, "invalid lease ID")
+		return
+	}
+
+	delete(s.leases, id)
+	s.revoked = append(s.revoked, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) logical(w http.ResponseWriter, r *http.Request, method string, path string) {
+	key, kv2, ok := s.storageKey(method, path)
+	if !ok {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	switch method {
+	case "GET":
+		secret, ok := s.secrets[key]
+		if !ok {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+
+		resp := map[string]interface{}{"data": secret.Data, "lease_duration": int(secret.LeaseDuration / time.Second), "renewable": secret.Renewable}
+		if secret.LeaseDuration > 0 {
+			id := s.newID(key)
+			s.leases[id] = key
+			resp["lease_id"] = id
+		}
+		if kv2 {
+			resp["data"] = map[string]interface{}{"data": secret.Data, "metadata": map[string]interface{}{"version": s.versions[key]}}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case "LIST":
+		keys := s.list(key)
+		if len(keys) == 0 {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case "PUT", "POST":
+		var data map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !kv2 {
+			s.write(key, Secret{Data: data})
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		nested, ok := data["data"].(map[string]interface{})
+		if !ok {
+			writeErrors(w, http.StatusBadRequest, "no data provided")
+			return
+		}
+		s.write(key, Secret{Data: nested})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": s.versions[key]}})
+	case "DELETE":
+		delete(s.secrets, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// storageKey maps a request path to the path its secret is stored at. KV
+// version 2 mounts are read and written under data/ and listed under metadata/.
+func (s *Server) storageKey(method string, path string) (string, bool, bool) {
+	spl := strings.SplitN(path, "/", 3)
+	if len(spl) < 2 || !s.kv2[spl[0]] {
+		return path, false, true
+	}
+
+	segment := "data"
+	if method == "LIST" {
+		segment = "metadata"
+	}
+	if spl[1] != segment {
+		return "", true, false
+	}
+	if len(spl) == 2 {
+		return spl[0], true, true
+	}
+
+	return spl[0] + "/" + spl[2], true, true
+}
+
+// list returns the keys directly under prefix, with a trailing slash on keys that have keys of their own
+func (s *Server) list(prefix string) []string {
+	prefix += "/"
+	seen := make(map[string]bool)
+	var keys []string
+	for path := range s.secrets {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(path, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (s *Server) write(path string, secret Secret) {
+	s.secrets[path] = secret
+	s.versions[path]++
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%v/%v", prefix, s.nextID)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeErrors(w http.ResponseWriter, status int, errors ...string) {
+	if errors == nil {
+		errors = []string{}
+	}
+	writeJSON(w, status, map[string]interface{}{"errors": errors})
+}
@@ -0,0 +1,88 @@
+package vaulttest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func newTestClient(t *testing.T, s *Server) *api.Client {
+	c, err := api.NewClient(&api.Config{Address: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAppRoleLogin(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddAppRole("role", "secret")
+
+	c := newTestClient(t, s)
+	secret, err := c.Logical().Write("auth/approle/login", map[string]interface{}{"role_id": "role", "secret_id": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil || secret.Auth == nil || len(secret.Auth.ClientToken) == 0 {
+		t.Fatalf("Expected a client token but got %v", secret)
+	}
+
+	c.SetToken(secret.Auth.ClientToken)
+	if _, err := c.Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("Expected the issued token to be valid but got %v", err)
+	}
+
+	if _, err := c.Logical().Write("auth/approle/login", map[string]interface{}{"role_id": "role", "secret_id": "wrong"}); err == nil {
+		t.Fatalf("Expected a wrong secret ID to fail")
+	}
+}
+
+func TestKV2(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.EnableKV2("secret")
+
+	c := newTestClient(t, s)
+	c.SetToken(RootToken)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Logical().Write("secret/data/app/db", map[string]interface{}{"data": map[string]interface{}{"password": "kv2-password"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secret, err := c.Logical().Read("secret/data/app/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := secret.Data["data"].(map[string]interface{})
+	metadata, _ := secret.Data["metadata"].(map[string]interface{})
+	if data["password"] != "kv2-password" || fmt.Sprint(metadata["version"]) != "2" {
+		t.Fatalf("Unexpected secret %v", secret.Data)
+	}
+
+	if secret, err := c.Logical().Read("secret/app/db"); err != nil || secret != nil {
+		t.Fatalf("Expected paths outside data/ not to be found but got %v, %v", secret, err)
+	}
+	if data, ok := s.Read("secret/app/db"); !ok || data["password"] != "kv2-password" {
+		t.Fatalf("Unexpected stored data %v", data)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetLatency(50 * time.Millisecond)
+
+	c := newTestClient(t, s)
+	c.SetToken(RootToken)
+	start := time.Now()
+	if _, err := c.Auth().Token().LookupSelf(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Expected the response to take at least 50ms but it took %v", elapsed)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is a source of values that templates read through the secret function
+type Provider interface {
+	// Name is the URI scheme the provider is registered under
+	Name() string
+	// Fetch returns the value at path. A non-empty field selects one field of a structured value.
+	Fetch(path string, field string) (string, error)
+	// List returns the keys under path
+	List(path string) ([]string, error)
+	// Metadata describes the value at path, such as its lease or modification time
+	Metadata(path string) (map[string]string, error)
+}
+
+// providerFactories create the providers that can be enabled with --provider, by name
+var providerFactories = map[string]func(Config) (Provider, error){
+	"vault":  newVaultProvider,
+	"consul": newConsulProvider,
+	"etcd":   newEtcdProvider,
+	"file":   newFileProvider,
+	"dir":    newDirProvider,
+	"env":    newEnvProvider,
+}
+
+// providers resolves secret URIs for templates
+var providers = newProviderRegistry()
+
+// providerNames returns the name of every registered provider
+func providerNames() []string {
+	var names []string
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ProviderRegistry resolves scheme://path#field URIs through the enabled
+// providers, creating each provider on first use and caching every value it
+// fetches. Fetched values are masked in logs whichever provider they come from.
+type ProviderRegistry struct {
+	mu        sync.Mutex
+	providers map[string]Provider
+	values    map[string]string
+}
+
+func newProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{providers: make(map[string]Provider), values: make(map[string]string)}
+}
+
+// Clear empties the cache so every URI is fetched again
+func (r *ProviderRegistry) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values = make(map[string]string)
+}
+
+// Fetch returns the value uri refers to
+func (r *ProviderRegistry) Fetch(uri string) (string, error) {
+	r.mu.Lock()
+	val, ok := r.values[uri]
+	r.mu.Unlock()
+	if ok {
+		return val, nil
+	}
+
+	p, path, field, err := r.resolve(uri)
+	if err != nil {
+		return "", err
+	}
+
+	val, err = p.Fetch(path, field)
+	if err != nil {
+		return "", fmt.Errorf("Error fetching %v: %v", uri, err)
+	}
+	redactor.Add(val)
+
+	r.mu.Lock()
+	r.values[uri] = val
+	r.mu.Unlock()
+
+	return val, nil
+}
+
+// List returns the keys under the path uri refers to
+func (r *ProviderRegistry) List(uri string) ([]string, error) {
+	p, path, field, err := r.resolve(uri)
+	if err != nil {
+		return nil, err
+	}
+	if len(field) > 0 {
+		return nil, fmt.Errorf("Error listing %v: Fields can't be listed", uri)
+	}
+
+	keys, err := p.List(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing %v: %v", uri, err)
+	}
+
+	return keys, nil
+}
+
+// Metadata describes the value uri refers to
+func (r *ProviderRegistry) Metadata(uri string) (map[string]string, error) {
+	p, path, _, err := r.resolve(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := p.Metadata(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading metadata of %v: %v", uri, err)
+	}
+
+	return meta, nil
+}
+
+func (r *ProviderRegistry) resolve(uri string) (Provider, string, string, error) {
+	name, path, field, err := parseSecretURI(uri)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.providers[name]; ok {
+		return p, path, field, nil
+	}
+
+	impl, enabled := providerImpl(config.Providers, name)
+	if _, ok := providerFactories[name]; !ok && !enabled {
+		return nil, "", "", fmt.Errorf("Unknown provider %q in %v. Expected one of %v", name, uri, strings.Join(providerNames(), ", "))
+	}
+	if !enabled {
+		return nil, "", "", fmt.Errorf("Provider %q is not enabled. Enable it with --provider %v", name, name)
+	}
+	factory, ok := providerFactories[impl]
+	if !ok {
+		return nil, "", "", fmt.Errorf("Unknown provider %q. Expected one of %v", impl, strings.Join(providerNames(), ", "))
+	}
+
+	p, err := factory(config)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Error configuring %v provider: %v", name, err)
+	}
+	r.providers[name] = p
+
+	return p, path, field, nil
+}
+
+// providerImpl returns the registered provider that serves URIs with scheme
+// name, and whether it is enabled in providers. An entry such as vault=dir
+// serves vault:// URIs with the dir provider. Every provider is enabled when
+// none are configured.
+func providerImpl(providers []string, name string) (string, bool) {
+	if len(providers) == 0 {
+		return name, true
+	}
+
+	for _, enabled := range providers {
+		spl := strings.SplitN(enabled, "=", 2)
+		if spl[0] != name {
+			continue
+		}
+		if len(spl) == 2 {
+			return spl[1], true
+		}
+		return name, true
+	}
+
+	return "", false
+}
+
+// parseSecretURI splits a URI such as vault://secret/db#password into its
+// provider, path and optional field
+func parseSecretURI(uri string) (string, string, string, error) {
+	i := strings.Index(uri, "://")
+	if i <= 0 {
+		return "", "", "", fmt.Errorf("Invalid secret URI %q. Expected provider://path[#field]", uri)
+	}
+
+	name, path, field := uri[:i], uri[i+3:], ""
+	if j := strings.LastIndex(path, "#"); j >= 0 {
+		path, field = path[:j], path[j+1:]
+	}
+	if len(path) == 0 {
+		return "", "", "", fmt.Errorf("Invalid secret URI %q. Expected provider://path[#field]", uri)
+	}
+
+	return name, path, field, nil
+}
+
+// selectField returns the value at the dotted field path within data
+func selectField(data interface{}, field string) (string, error) {
+	for _, key := range strings.Split(field, ".") {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("Field %v not found", field)
+		}
+		if data, ok = m[key]; !ok {
+			return "", fmt.Errorf("Field %v not found", field)
+		}
+	}
+
+	switch data.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("Field %v is not a single value", field)
+	case nil:
+		return "", nil
+	}
+
+	return fmt.Sprint(data), nil
+}
+
+// vaultProvider reads secrets through the shared vault client
+type vaultProvider struct{}
+
+func newVaultProvider(Config) (Provider, error) {
+	return vaultProvider{}, nil
+}
+
+func (vaultProvider) Name() string { return "vault" }
+
+// Fetch returns the value field of the secret at path, or field if it is given
+func (vaultProvider) Fetch(path string, field string) (string, error) {
+	var val string
+	var err error
+	if len(field) == 0 || field == "value" {
+		val, err = vault.GetStringValue(path)
+	} else if reader, ok := unwrapVault(vault).(FieldReader); ok {
+		val, err = reader.GetStringField(path, field)
+	} else {
+		err = fmt.Errorf("This vault client can't read field %v", field)
+	}
+	return val, err
+}
+
+func (vaultProvider) List(path string) ([]string, error) {
+	lister, ok := unwrapVault(vault).(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("This vault client can't list keys")
+	}
+
+	return lister.ListKeys(path)
+}
+
+func (vaultProvider) Metadata(path string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if reader, ok := unwrapVault(vault).(LeaseReader); ok {
+		lease, err := reader.LeaseDuration(path)
+		if err != nil {
+			return nil, err
+		}
+		meta["lease_duration"] = lease.String()
+	}
+
+	return meta, nil
+}
+
+// kvProvider reads keys from a KV store. Fields select from values holding JSON objects.
+type kvProvider struct {
+	name string
+	open func() (KV, error)
+}
+
+func newConsulProvider(Config) (Provider, error) {
+	return kvProvider{name: "consul", open: consulKV}, nil
+}
+
+func newEtcdProvider(Config) (Provider, error) {
+	return kvProvider{name: "etcd", open: etcdKV}, nil
+}
+
+func (p kvProvider) Name() string { return p.name }
+
+func (p kvProvider) Fetch(path string, field string) (string, error) {
+	val, err := kvGetString(p.open, path)
+	if err != nil || len(field) == 0 {
+		return val, err
+	}
+
+	data, err := fromJSON(val)
+	if err != nil {
+		return "", fmt.Errorf("Can't select field %v: %v", field, err)
+	}
+
+	return selectField(data, field)
+}
+
+func (p kvProvider) List(path string) ([]string, error) {
+	tree, err := kvGetTree(p.open, path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (p kvProvider) Metadata(path string) (map[string]string, error) {
+	if _, err := kvGetString(p.open, path); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{}, nil
+}
+
+// fileProvider reads local files with the same checks as the file function.
+// Fields select from YAML, JSON, TOML or HCL files by extension.
+type fileProvider struct{}
+
+func newFileProvider(Config) (Provider, error) {
+	return fileProvider{}, nil
+}
+
+func (fileProvider) Name() string { return "file" }
+
+func (fileProvider) Fetch(path string, field string) (string, error) {
+	contents, err := readSecretFile(path)
+	if err != nil || len(field) == 0 {
+		return contents, err
+	}
+
+	data, err := decodeDataFile(path, contents)
+	if err != nil {
+		return "", fmt.Errorf("Can't select field %v: %v", field, err)
+	}
+
+	return selectField(normalizeData(data), field)
+}
+
+func (fileProvider) List(path string) ([]string, error) {
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+
+	return names, nil
+}
+
+func (fileProvider) Metadata(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"size":     fmt.Sprint(info.Size()),
+		"mode":     info.Mode().String(),
+		"modified": info.ModTime().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// envProvider reads environment variables, including those from --env-file
+type envProvider struct{}
+
+func newEnvProvider(Config) (Provider, error) {
+	return envProvider{}, nil
+}
+
+func (envProvider) Name() string { return "env" }
+
+func (envProvider) Fetch(path string, field string) (string, error) {
+	if len(field) > 0 {
+		return "", fmt.Errorf("Environment variables have no fields")
+	}
+
+	val, ok, err := lookupEnv(path)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("Environment variable %v is not set", path)
+	}
+
+	return val, nil
+}
+
+// List returns the names of the environment variables starting with path
+func (envProvider) List(path string) ([]string, error) {
+	environment, err := env()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range environment {
+		if strings.HasPrefix(key, path) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (envProvider) Metadata(path string) (map[string]string, error) {
+	_, ok, err := lookupEnv(path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("Environment variable %v is not set", path)
+	}
+
+	return map[string]string{}, nil
+}
+
+func secretGetString(uri string) (string, error) {
+	return providers.Fetch(uri)
+}
+
+func secretList(uri string) ([]string, error) {
+	return providers.List(uri)
+}
+
+func secretMetadata(uri string) (map[string]string, error) {
+	return providers.Metadata(uri)
+}
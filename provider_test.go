@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fieldVaultClient is a fake vault holding secrets with several fields
+type fieldVaultClient struct {
+	secrets map[string]map[string]string
+	reads   *int
+}
+
+func (c fieldVaultClient) GetStringValue(path string) (string, error) {
+	return c.GetStringField(path, "value")
+}
+
+func (c fieldVaultClient) GetStringField(path string, field string) (string, error) {
+	*c.reads++
+	val, ok := c.secrets[path][field]
+	if !ok {
+		return "", fmt.Errorf("secret missing '%v' key", field)
+	}
+
+	return val, nil
+}
+
+func (c fieldVaultClient) ListKeys(path string) ([]string, error) {
+	var keys []string
+	for key := range c.secrets {
+		if strings.HasPrefix(key, path) {
+			keys = append(keys, strings.TrimPrefix(key, path))
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (c fieldVaultClient) LeaseDuration(path string) (time.Duration, error) {
+	return time.Hour, nil
+}
+
+func TestSecretFunction(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	server := newTestConsul(t, "", map[string]string{
+		"service/app/flags":  "beta",
+		"service/app/config": `{"db": {"port": 5432}}`,
+	})
+	defer server.Close()
+	config.ConsulAddr = server.URL
+
+	dir, err := ioutil.TempDir("", "polymerase_test_provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := writeTestFile(t, dir, "key.pem", "KEY")
+	settings := writeTestFile(t, dir, "settings.yaml", "db:\n  host: db.internal\n")
+
+	reads := 0
+	vault = newCachingVault(fieldVaultClient{reads: &reads, secrets: map[string]map[string]string{
+		"secret/db": {"value": "db-value", "username": "app-user", "password": "s3cr3t"},
+	}})
+	os.Setenv("POLYMERASE_TEST_PROVIDER", "env-provider-value")
+
+	tmpl, err := TemplateFromString(`{{ secret "vault://secret/db#username" }}:{{ secret "vault://secret/db#password" }} ` +
+		`{{ secret "vault://secret/db" }} {{ secret "consul://service/app/flags" }} {{ secret "consul://service/app/config#db.port" }} ` +
+		`{{ secret "file://` + key + `" }} {{ secret "file://` + settings + `#db.host" }} {{ secret "env://POLYMERASE_TEST_PROVIDER" }} ` +
+		`{{ secret "vault://secret/db#password" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "app-user:s3cr3t db-value beta 5432 KEY db.internal env-provider-value s3cr3t", t)
+
+	// Each URI is fetched once
+	if reads != 3 {
+		t.Fatalf("Expected 3 vault reads but got %v", reads)
+	}
+
+	// Values read from any provider are masked
+	for _, secret := range []string{"s3cr3t", "env-provider-value"} {
+		if masked := redactor.Redact("value " + secret); strings.Contains(masked, secret) {
+			t.Fatalf("Expected %v to be masked but got %v", secret, masked)
+		}
+	}
+
+	for uri, expected := range map[string]string{
+		"vault://secret/db#missing":       "Error fetching vault://secret/db#missing: secret missing 'missing' key",
+		"consul://service/app/missing":    "Error fetching consul://service/app/missing: Consul key service/app/missing not found",
+		"env://POLYMERASE_TEST_UNSET":     "Environment variable POLYMERASE_TEST_UNSET is not set",
+		"file://" + settings + "#db.port": "Field db.port not found",
+		"ftp://example.com/secret":        `Unknown provider "ftp"`,
+		"secret/db":                       "Invalid secret URI",
+	} {
+		if _, err := providers.Fetch(uri); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %v to fail with %q but got %v", uri, expected, err)
+		}
+	}
+}
+
+func TestProviderRegistry(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	reads := 0
+	vault = newCachingVault(fieldVaultClient{reads: &reads, secrets: map[string]map[string]string{
+		"secret/app/db":  {"token": "db-token"},
+		"secret/app/api": {"token": "api-token"},
+	}})
+
+	keys, err := providers.List("vault://secret/app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "api,db" {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+
+	meta, err := providers.Metadata("vault://secret/app/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["lease_duration"] != "1h0m0s" {
+		t.Fatalf("Expected a lease duration of 1h0m0s but got %v", meta)
+	}
+
+	// Clearing the cache fetches values again
+	providers.Fetch("vault://secret/app/db#token")
+	providers.Clear()
+	providers.Fetch("vault://secret/app/db#token")
+	if reads != 2 {
+		t.Fatalf("Expected 2 vault reads but got %v", reads)
+	}
+
+	// Only enabled providers can be used
+	config.Providers = []string{"env"}
+	providers = newProviderRegistry()
+	if _, err := providers.Fetch("vault://secret/app/db"); err == nil || !strings.Contains(err.Error(), `Provider "vault" is not enabled`) {
+		t.Fatalf("Expected a disabled provider to fail but got %v", err)
+	}
+	if _, err := providers.List("env://POLYMERASE_TEST"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "b.json", `{"list": [1, 2]}`)
+	writeTestFile(t, dir, "a.txt", "A")
+
+	p := fileProvider{}
+	names, err := p.List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "a.txt,b.json" {
+		t.Fatalf("Unexpected names %v", names)
+	}
+
+	if _, err := p.Fetch(filepath.Join(dir, "b.json"), "list"); err == nil || !strings.Contains(err.Error(), "not a single value") {
+		t.Fatalf("Expected selecting a list to fail but got %v", err)
+	}
+
+	meta, err := p.Metadata(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["size"] != "1" {
+		t.Fatalf("Expected a size of 1 but got %v", meta)
+	}
+}
+
+func TestParseSecretURI(t *testing.T) {
+	for uri, expected := range map[string][3]string{
+		"vault://secret/db#password": {"vault", "secret/db", "password"},
+		"consul://service/app/flags": {"consul", "service/app/flags", ""},
+		"file:///etc/app/key.pem":    {"file", "/etc/app/key.pem", ""},
+		"env://HOME":                 {"env", "HOME", ""},
+	} {
+		name, path, field, err := parseSecretURI(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if [3]string{name, path, field} != expected {
+			t.Fatalf("Expected %v to parse as %v but got %v", uri, expected, [3]string{name, path, field})
+		}
+	}
+
+	for _, uri := range []string{"secret/db", "://secret/db", "vault://", "vault://#password"} {
+		if _, _, _, err := parseSecretURI(uri); err == nil {
+			t.Fatalf("Expected %v to be invalid", uri)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// mask replaces secret values in logs, errors and diffs
+const mask = "********"
+
+// Redactor tracks secret values seen during a run and scrubs them from text.
+// Only exact values are masked: a value that was never read this run, such as
+// the one a rotated secret replaced, or one derived from a secret, such as its
+// base64 encoding or a trimmed part of it, is printed as it is.
+type Redactor struct {
+	mu       sync.Mutex
+	disabled bool
+	secrets  []string
+}
+
+// Add records a secret to be redacted from now on
+func (r *Redactor) Add(secret string) {
+	if len(secret) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.secrets {
+		if s == secret {
+			return
+		}
+	}
+
+	// Longest first, so a secret containing another is still masked whole
+	r.secrets = append(r.secrets, secret)
+	sort.Sort(byLengthDesc(r.secrets))
+}
+
+// SetEnabled turns redaction on or off
+func (r *Redactor) SetEnabled(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.disabled = !enabled
+}
+
+// Enabled reports whether secrets are being redacted
+func (r *Redactor) Enabled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !r.disabled
+}
+
+// Redact replaces every secret in str with a mask
+func (r *Redactor) Redact(str string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.disabled {
+		return str
+	}
+
+	for _, secret := range r.secrets {
+		str = strings.Replace(str, secret, mask, -1)
+	}
+
+	return str
+}
+
+// Writer returns a writer that redacts everything written to it before passing it on to w
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return redactingWriter{redactor: r, w: w}
+}
+
+type redactingWriter struct {
+	redactor *Redactor
+	w        io.Writer
+}
+
+// Write redacts p as a whole, so callers such as log.Logger that write a
+// line at a time never split a secret across writes
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+type byLengthDesc []string
+
+func (s byLengthDesc) Len() int           { return len(s) }
+func (s byLengthDesc) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+func (s byLengthDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
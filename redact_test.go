@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	r := &Redactor{}
+	r.Add("")
+	r.Add("hunter2")
+	r.Add("hunter2hunter2")
+
+	if redacted := r.Redact("password=hunter2hunter2 old=hunter2"); redacted != "password=******** old=********" {
+		t.Fatalf("Unexpected redaction %q", redacted)
+	}
+
+	output := &bytes.Buffer{}
+	l := log.New(r.Writer(output), "", 0)
+	l.Printf("Error populating template: bad value %q", "hunter2")
+	validateOutput(output, "Error populating template: bad value \"********\"\n", t)
+
+	r.SetEnabled(false)
+	if redacted := r.Redact("hunter2"); redacted != "hunter2" {
+		t.Fatalf("Expected redaction to be disabled but got %q", redacted)
+	}
+}
+
+func TestVaultValuesRedacted(t *testing.T) {
+	context := newTestContext("redacted-last-name", "{{ vault \"secret_agents/007/last_name\" }}", &bytes.Buffer{})
+	setupTest(context)
+
+	// Capture the logger through the same redactor vault values are added to
+	output := &bytes.Buffer{}
+	logger.SetOutput(redactor.Writer(output))
+	defer logger.SetOutput(redactor.Writer(os.Stderr))
+
+	run(rootCmd, []string{})
+	logger.Printf("Rendered JAMES redacted-last-name")
+	if !strings.HasSuffix(output.String(), "Rendered JAMES ********\n") {
+		t.Fatalf("Expected vault values to be redacted from logs but got %q", output.String())
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// refreshLease is the refresh directive value that derives a template's poll
+// interval from the leases of the secrets it references
+const refreshLease = "lease"
+
+// minRefreshInterval bounds how often secrets are polled, however short their leases
+const minRefreshInterval = time.Second
+
+// Refresher polls the vault secrets referenced by templates and reports when
+// any of them changed. Each template is polled at Interval unless its first
+// line holds a refresh directive.
+type Refresher struct {
+	Vault     *cachingVault
+	Pairs     []TemplatePair
+	Interval  time.Duration
+	schedules []*refreshSchedule
+}
+
+// refreshSchedule is when a template's secrets are next polled and the hash
+// of the values it was last rendered with
+type refreshSchedule struct {
+	source   string
+	interval time.Duration
+	paths    []string
+	fields   map[string][]string
+	hash     string
+	next     time.Time
+}
+
+// Reset re-reads every template and hashes the cached values of its secrets,
+// keeping the next poll of templates whose interval didn't change. Call it after every render.
+func (r *Refresher) Reset(now time.Time) error {
+	previous := make(map[string]*refreshSchedule, len(r.schedules))
+	for _, s := range r.schedules {
+		previous[s.source] = s
+	}
+
+	schedules := make([]*refreshSchedule, 0, len(r.Pairs))
+	for _, pair := range r.Pairs {
+		s, err := r.schedule(pair.Source)
+		if err != nil {
+			return err
+		}
+		if s.interval <= 0 || len(s.paths) == 0 {
+			continue
+		}
+
+		s.hash, err = secretsHash(s.paths, s.fields, r.cachedField)
+		if err != nil {
+			return fmt.Errorf("%v: %v", s.source, err)
+		}
+
+		s.next = now.Add(s.interval)
+		if p, ok := previous[s.source]; ok && p.interval == s.interval {
+			s.next = p.next
+		}
+		schedules = append(schedules, s)
+	}
+	r.schedules = schedules
+
+	return nil
+}
+
+// Next returns when the next template is due to be polled, or the zero time
+// if no template needs polling
+func (r *Refresher) Next() time.Time {
+	var next time.Time
+	for _, s := range r.schedules {
+		if next.IsZero() || s.next.Before(next) {
+			next = s.next
+		}
+	}
+
+	return next
+}
+
+// Poll refetches the secrets of every template due at now, bypassing the
+// cache. When a template's secrets hash differently from its last render the
+// cache is updated with the new values and Poll reports a change.
+func (r *Refresher) Poll(now time.Time) (bool, error) {
+	changed := false
+	var pollErr error
+	for _, s := range r.schedules {
+		if now.Before(s.next) {
+			continue
+		}
+		s.next = now.Add(s.interval)
+
+		fresh := make(map[string]string, len(s.paths))
+		hash, err := secretsHash(s.paths, s.fields, func(path string, field string) (string, error) {
+			if field != vaultValueField {
+				return r.readField(path, field)
+			}
+			val, err := r.Vault.vault.GetStringValue(path)
+			fresh[path] = val
+			return val, err
+		})
+		if err != nil {
+			pollErr = fmt.Errorf("%v: %v", s.source, err)
+			continue
+		}
+		if hash == s.hash {
+			continue
+		}
+
+		for path, val := range fresh {
+			redactor.Add(val)
+			r.Vault.set(path, val)
+		}
+		s.hash = hash
+		changed = true
+	}
+
+	return changed, pollErr
+}
+
+// schedule reads the vault paths a template references and its poll interval
+func (r *Refresher) schedule(source string) (*refreshSchedule, error) {
+	contents, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := parseTemplate(source, string(contents), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", source, err)
+	}
+
+	refs := TemplateReferences(tmpl)
+	s := &refreshSchedule{source: source, interval: r.Interval, paths: refs.VaultPaths, fields: refs.VaultFields}
+	header := strings.SplitN(string(contents), "\n", 2)[0]
+	if m := refreshDirective.FindStringSubmatch(header); m != nil {
+		if m[1] == refreshLease {
+			s.interval, err = r.leaseInterval(s.paths)
+		} else {
+			s.interval, err = time.ParseDuration(m[1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: Invalid refresh interval %q: %v", source, m[1], err)
+		}
+	}
+
+	if s.interval > 0 && s.interval < minRefreshInterval {
+		s.interval = minRefreshInterval
+	}
+
+	return s, nil
+}
+
+// leaseInterval returns half the shortest lease of paths, so secrets are
+// refetched well before they expire. Without any leases it falls back to Interval.
+func (r *Refresher) leaseInterval(paths []string) (time.Duration, error) {
+	reader, ok := r.Vault.vault.(LeaseReader)
+	if !ok {
+		return 0, fmt.Errorf("vault client can't report leases")
+	}
+
+	var shortest time.Duration
+	for _, path := range paths {
+		lease, err := reader.LeaseDuration(path)
+		if err != nil {
+			return 0, err
+		}
+		if lease > 0 && (shortest == 0 || lease < shortest) {
+			shortest = lease
+		}
+	}
+
+	if shortest == 0 {
+		return r.Interval, nil
+	}
+
+	return shortest / 2, nil
+}
+
+// cachedField returns the cached value of path's value field. Other fields
+// are read by the secret function, whose values aren't cached between renders,
+// so they are read from vault.
+func (r *Refresher) cachedField(path string, field string) (string, error) {
+	if field == vaultValueField {
+		return r.Vault.GetStringValue(path)
+	}
+
+	return r.readField(path, field)
+}
+
+// readField reads field of the secret at path from vault
+func (r *Refresher) readField(path string, field string) (string, error) {
+	reader, ok := r.Vault.vault.(FieldReader)
+	if !ok {
+		return "", fmt.Errorf("vault client can't read field %v", field)
+	}
+
+	return reader.GetStringField(path, field)
+}
+
+// secretsHash hashes the values of the fields of paths, in order, as returned by get
+func secretsHash(paths []string, fields map[string][]string, get func(string, string) (string, error)) (string, error) {
+	h := sha256.New()
+	for _, path := range paths {
+		for _, field := range fields[path] {
+			val, err := get(path, field)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%v\x00%v\x00%v\x00", path, field, val)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
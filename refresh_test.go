@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rotatingVaultClient is a fake vault whose values can be changed between polls
+type rotatingVaultClient struct {
+	values map[string]string
+	leases map[string]time.Duration
+}
+
+func (c rotatingVaultClient) GetStringValue(path string) (string, error) {
+	val, ok := c.values[path]
+	if !ok {
+		return "", fmt.Errorf("secret not found")
+	}
+
+	return val, nil
+}
+
+// GetStringField returns the value stored as path#field
+func (c rotatingVaultClient) GetStringField(path string, field string) (string, error) {
+	return c.GetStringValue(path + "#" + field)
+}
+
+func (c rotatingVaultClient) LeaseDuration(path string) (time.Duration, error) {
+	return c.leases[path], nil
+}
+
+func TestRefresher(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := rotatingVaultClient{
+		values: map[string]string{"secret/db": "one", "secret/api": "key"},
+		leases: map[string]time.Duration{"secret/api": 10 * time.Minute},
+	}
+	cache := newCachingVault(fake)
+	vault = cache
+
+	pairs := []TemplatePair{
+		{Source: writeTestFile(t, dir, "db.tmpl", "{{ vault \"secret/db\" }}"), Destination: filepath.Join(dir, "db")},
+		{Source: writeTestFile(t, dir, "api.tmpl", "# polymerase:refresh lease\n{{ vault \"secret/api\" }}"), Destination: filepath.Join(dir, "api")},
+		{Source: writeTestFile(t, dir, "static.tmpl", "static"), Destination: filepath.Join(dir, "static")},
+	}
+
+	r := &Refresher{Vault: cache, Pairs: pairs, Interval: time.Minute}
+	start := time.Unix(1478174400, 0)
+	if err := r.Reset(start); err != nil {
+		t.Fatal(err)
+	}
+	if next := r.Next(); !next.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Expected next poll at %v but got %v", start.Add(time.Minute), next)
+	}
+
+	// Nothing is due yet
+	fake.values["secret/db"] = "two"
+	if changed, err := r.Poll(start.Add(30 * time.Second)); err != nil || changed {
+		t.Fatalf("Expected no change but got %v, %v", changed, err)
+	}
+
+	// The rotated secret is picked up once due
+	changed, err := r.Poll(start.Add(time.Minute))
+	if err != nil || !changed {
+		t.Fatalf("Expected a change but got %v, %v", changed, err)
+	}
+	if val, _ := cache.GetStringValue("secret/db"); val != "two" {
+		t.Fatalf("Expected the cache to hold the new value but got %v", val)
+	}
+
+	// Unchanged secrets don't report a change
+	if changed, err := r.Poll(start.Add(2 * time.Minute)); err != nil || changed {
+		t.Fatalf("Expected no change but got %v, %v", changed, err)
+	}
+
+	// The lease template is polled at half its lease
+	fake.values["secret/api"] = "rotated"
+	if changed, err := r.Poll(start.Add(4 * time.Minute)); err != nil || changed {
+		t.Fatalf("Expected no change before half the lease but got %v, %v", changed, err)
+	}
+	if changed, err := r.Poll(start.Add(5 * time.Minute)); err != nil || !changed {
+		t.Fatalf("Expected a change after half the lease but got %v, %v", changed, err)
+	}
+}
+
+func TestRefresherRenders(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := rotatingVaultClient{values: map[string]string{"secret/db": "one"}}
+	cache := newCachingVault(fake)
+	vault = cache
+
+	src := writeTestFile(t, dir, "db.tmpl", "PASSWORD={{ vault \"secret/db\" }}\n")
+	pairs := []TemplatePair{{Source: src, Destination: filepath.Join(dir, "db.env")}}
+	r := &Refresher{Vault: cache, Pairs: pairs, Interval: time.Minute}
+
+	if _, err := renderChanged(pairs); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := r.Reset(start); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.values["secret/db"] = "two"
+	if changed, err := r.Poll(start.Add(time.Minute)); err != nil || !changed {
+		t.Fatalf("Expected a change but got %v, %v", changed, err)
+	}
+	if _, err := renderChanged(pairs); err != nil {
+		t.Fatal(err)
+	}
+	validateFile(pairs[0].Destination, "PASSWORD=two\n", t)
+}
+
+func TestRefresherSecretFields(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := rotatingVaultClient{values: map[string]string{"secret/db#password": "password-one"}}
+	cache := newCachingVault(fake)
+	vault = cache
+
+	src := writeTestFile(t, dir, "db.tmpl", "PASSWORD={{ secret \"vault://secret/db#password\" }}\n")
+	pairs := []TemplatePair{{Source: src, Destination: filepath.Join(dir, "db.env")}}
+	r := &Refresher{Vault: cache, Pairs: pairs, Interval: time.Minute}
+
+	if _, err := renderChanged(pairs); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := r.Reset(start); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields read with the secret function are polled too
+	fake.values["secret/db#password"] = "password-two"
+	if changed, err := r.Poll(start.Add(time.Minute)); err != nil || !changed {
+		t.Fatalf("Expected a change but got %v, %v", changed, err)
+	}
+	providers.Clear()
+	if _, err := renderChanged(pairs); err != nil {
+		t.Fatal(err)
+	}
+	validateFile(pairs[0].Destination, "PASSWORD=password-two\n", t)
+}
+
+func TestRefreshDirective(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &Refresher{Vault: newCachingVault(rotatingVaultClient{}), Interval: time.Minute}
+	for contents, expected := range map[string]time.Duration{
+		"{{ vault \"secret/a\" }}":                             time.Minute,
+		"# polymerase:refresh 5m\n{{ vault \"secret/a\" }}":    5 * time.Minute,
+		"# polymerase:refresh 10ms\n{{ vault \"secret/a\" }}":  minRefreshInterval,
+		"# polymerase:refresh lease\n{{ vault \"secret/a\" }}": time.Minute,
+		"# polymerase:refresh 0\n{{ vault \"secret/a\" }}":     0,
+	} {
+		s, err := r.schedule(writeTestFile(t, dir, "a.tmpl", contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.interval != expected {
+			t.Fatalf("Expected interval %v for %q but got %v", expected, contents, s.interval)
+		}
+	}
+
+	if _, err := r.schedule(writeTestFile(t, dir, "a.tmpl", "# polymerase:refresh soon\n")); err == nil {
+		t.Fatalf("Expected an invalid interval to fail")
+	}
+
+	// The directive line is not rendered
+	tmpl, err := TemplateFromString("# polymerase:refresh 5m\nBODY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "BODY", t)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TemplatePair maps a template file to the destination it is rendered to
+type TemplatePair struct {
+	Source      string
+	Destination string
+}
+
+// ParseTemplatePair parses a pair in the form source:destination
+func ParseTemplatePair(str string) (TemplatePair, error) {
+	spl := strings.SplitN(str, ":", 2)
+	if len(spl) != 2 || len(spl[0]) == 0 || len(spl[1]) == 0 {
+		return TemplatePair{}, fmt.Errorf("Invalid template pair %q. Expected source:destination", str)
+	}
+
+	return TemplatePair{Source: spl[0], Destination: spl[1]}, nil
+}
+
+// TemplatePairsFromManifest reads template pairs from a file containing one
+// source:destination pair per line. Blank lines and lines starting with # are ignored.
+func TemplatePairsFromManifest(filename string) ([]TemplatePair, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pairs []TemplatePair
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pair, err := ParseTemplatePair(line)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", filename, lineno, err)
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, scanner.Err()
+}
+
+// RenderPairs renders every pair in memory. Nothing is written unless all of them succeed.
+func RenderPairs(pairs []TemplatePair, data interface{}) ([][]byte, error) {
+	outputs := make([][]byte, len(pairs))
+	for i, pair := range pairs {
+		out, err := renderFile(pair.Source, data)
+		if err != nil {
+			return nil, err
+		}
+		outputs[i] = out
+	}
+
+	return outputs, nil
+}
+
+func renderFile(filename string, data interface{}) ([]byte, error) {
+	tmpl, err := TemplateFromFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WritePairs writes each rendered output to its pair's destination, keeping
+// the mode of any destination that already exists. See WriteFiles.
+func WritePairs(pairs []TemplatePair, outputs [][]byte) error {
+	return WriteFiles(PairFiles(pairs, outputs))
+}
+
+// PairFiles matches each rendered output with its pair's destination, keeping
+// the mode of any destination that already exists
+func PairFiles(pairs []TemplatePair, outputs [][]byte) []RenderedFile {
+	files := make([]RenderedFile, len(pairs))
+	for i, pair := range pairs {
+		files[i] = RenderedFile{Path: pair.Destination, Data: outputs[i], Mode: destinationMode(pair.Destination, 0644)}
+	}
+
+	return files
+}
+
+// ChangedFiles returns the files whose data differs from what is on disk
+func ChangedFiles(files []RenderedFile) []RenderedFile {
+	var changed []RenderedFile
+	for _, file := range files {
+		current, err := ioutil.ReadFile(file.Path)
+		if err != nil || !bytes.Equal(current, file.Data) {
+			changed = append(changed, file)
+		}
+	}
+
+	return changed
+}
+
+// RenderedFile is output waiting to be written to disk
+type RenderedFile struct {
+	Path string
+	Data []byte
+	Mode os.FileMode
+	// Secret marks output of a template that reads secrets, whose changed lines diffs mask
+	Secret bool
+}
+
+// WriteFiles stages every file next to its destination and then renames them
+// into place one at a time. If a rename fails, the destinations already
+// replaced are restored to what they held before, so a failed write leaves
+// every destination as it was. Shutting down waits for it to finish.
+func WriteFiles(files []RenderedFile) error {
+	writeLock.Lock()
+	defer writeLock.Unlock()
+
+	staged := make([]string, 0, len(files))
+	cleanup := func() {
+		for _, name := range staged {
+			os.Remove(name)
+		}
+	}
+
+	for _, file := range files {
+		name, err := stageFile(file.Path, file.Data, file.Mode)
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("%v: %v", file.Path, err)
+		}
+		staged = append(staged, name)
+	}
+
+	backups := make([]*RenderedFile, len(files))
+	for i, file := range files {
+		backups[i] = backupFile(file.Path)
+	}
+
+	for i, file := range files {
+		if err := os.Rename(staged[i], file.Path); err != nil {
+			cleanup()
+			restoreFiles(files[:i], backups[:i])
+			return fmt.Errorf("%v: %v", file.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// backupFile returns the current contents of path, or nil if it doesn't exist
+func backupFile(path string) *RenderedFile {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	return &RenderedFile{Path: path, Data: data, Mode: destinationMode(path, 0644)}
+}
+
+// restoreFiles puts back the backups of files that have already been
+// replaced, removing the ones that didn't exist before
+func restoreFiles(files []RenderedFile, backups []*RenderedFile) {
+	for i, file := range files {
+		if backups[i] == nil {
+			os.Remove(file.Path)
+			continue
+		}
+
+		name, err := stageFile(file.Path, backups[i].Data, backups[i].Mode)
+		if err == nil {
+			err = os.Rename(name, file.Path)
+		}
+		if err != nil {
+			logger.Printf("Error restoring %v: %v", file.Path, err)
+		}
+	}
+}
+
+func destinationMode(dest string, def os.FileMode) os.FileMode {
+	if fi, err := os.Stat(dest); err == nil {
+		return fi.Mode().Perm()
+	}
+
+	return def
+}
+
+// stageFile writes data to a temporary file in the same directory as dest
+func stageFile(dest string, data []byte, mode os.FileMode) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
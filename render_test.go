@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTemplatePair(t *testing.T) {
+	pair, err := ParseTemplatePair("app.env.tmpl:/etc/app.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.Source != "app.env.tmpl" || pair.Destination != "/etc/app.env" {
+		t.Fatalf("Unexpected pair %v", pair)
+	}
+
+	for _, invalid := range []string{"app.env.tmpl", ":/etc/app.env", "app.env.tmpl:"} {
+		if _, err := ParseTemplatePair(invalid); err == nil {
+			t.Fatalf("Pair %v was valid but should have been invalid", invalid)
+		}
+	}
+}
+
+func TestTemplatePairsFromManifest(t *testing.T) {
+	f, err := ioutil.TempFile("", "polymerase_test_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, _ = f.WriteString("# services\nnginx.conf.tmpl:/etc/nginx/nginx.conf\n\n  app.env.tmpl:/etc/app.env  \n")
+	_ = f.Close()
+
+	pairs, err := TemplatePairsFromManifest(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs[1].Source != "app.env.tmpl" || pairs[1].Destination != "/etc/app.env" {
+		t.Fatalf("Unexpected pairs %v", pairs)
+	}
+}
+
+func TestWriteFilesRollsBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	existing := writeTestFile(t, dir, "existing", "old")
+	created := filepath.Join(dir, "created")
+	// Renaming a file over a directory fails after the first two renames succeed
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.Mkdir(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteFiles([]RenderedFile{
+		{Path: existing, Data: []byte("new"), Mode: 0644},
+		{Path: created, Data: []byte("new"), Mode: 0644},
+		{Path: blocked, Data: []byte("new"), Mode: 0644},
+	})
+	if err == nil {
+		t.Fatalf("Expected writing over a directory to fail")
+	}
+
+	validateFile(existing, "old", t)
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Fatalf("Expected %v to be removed but got %v", created, err)
+	}
+
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("Expected staged files to be cleaned up but found %v entries", len(names))
+	}
+}
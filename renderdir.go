@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var renderDirCmd = &cobra.Command{
+	Use:     "render-dir <src> <dst>",
+	Short:   "Render a directory of templates",
+	Long:    "Mirrors the src directory into dst. Files ending in .tmpl are rendered with the suffix stripped, partials (_*.tmpl) are skipped and all other files are copied verbatim.",
+	Example: "polymerase render-dir conf.d.tmpl /etc/app/conf.d",
+	Run:     runRenderDir,
+}
+
+func init() {
+	renderDirCmd.Flags().BoolVar(&config.Prune, "prune", false, "Remove files from dst that no longer have a source in src.")
+	rootCmd.AddCommand(renderDirCmd)
+}
+
+func runRenderDir(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		return
+	}
+
+	if _, err := config.Validate(); err != nil {
+		logger.Fatalf("Error validating config: %v", err)
+	}
+
+	handleShutdown()
+	configureVault()
+
+	data, err := templateContext()
+	if err != nil {
+		logger.Fatalf("Error loading data: %v", err)
+	}
+
+	if err := RenderDir(args[0], args[1], data, config.Prune); err != nil {
+		logger.Fatalf("Error rendering directory: %v", err)
+	}
+}
+
+// RenderDir mirrors the src directory tree into dst, rendering templates and
+// copying everything else with its mode preserved. Nothing is written unless every
+// template renders. If prune is set, files and directories in dst without a source are removed.
+func RenderDir(src string, dst string, data interface{}, prune bool) error {
+	var dirs []RenderedFile
+	var files []RenderedFile
+
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			dirs = append(dirs, RenderedFile{Path: target, Mode: fi.Mode().Perm()})
+		case !fi.Mode().IsRegular(), IsPartial(path):
+			return nil
+		case strings.HasSuffix(path, TemplateSuffix):
+			out, err := renderFile(path, data)
+			if err != nil {
+				return err
+			}
+			files = append(files, RenderedFile{Path: strings.TrimSuffix(target, TemplateSuffix), Data: out, Mode: fi.Mode().Perm()})
+		default:
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files = append(files, RenderedFile{Path: target, Data: contents, Mode: fi.Mode().Perm()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir.Path, dir.Mode); err != nil {
+			return err
+		}
+		if err := os.Chmod(dir.Path, dir.Mode); err != nil {
+			return err
+		}
+	}
+
+	if err := WriteFiles(files); err != nil {
+		return err
+	}
+
+	if prune {
+		return PruneDir(dst, append(dirs, files...))
+	}
+
+	return nil
+}
+
+// PruneDir removes every file and directory under dst that isn't in keep
+func PruneDir(dst string, keep []RenderedFile) error {
+	keepPaths := make(map[string]bool, len(keep))
+	for _, file := range keep {
+		keepPaths[filepath.Clean(file.Path)] = true
+	}
+
+	var remove []string
+	err := filepath.Walk(dst, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if keepPaths[filepath.Clean(path)] {
+			return nil
+		}
+
+		remove = append(remove, path)
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(remove)
+	for _, path := range remove {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
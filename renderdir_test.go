@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderDir(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	vault = context.mockVault
+
+	src, err := ioutil.TempDir("", "polymerase_test_src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	dst, err := ioutil.TempDir("", "polymerase_test_dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := os.Mkdir(filepath.Join(src, "conf.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, src, "conf.d/app.conf.tmpl", "name={{ vault \"secret_agents/007/last_name\" }}")
+	script := writeTestFile(t, src, "run.sh", "#!/bin/sh")
+	if err := os.Chmod(script, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dst, "stale.conf", "stale")
+
+	if err := RenderDir(src, dst, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	validateFile(filepath.Join(dst, "conf.d", "app.conf"), "name=BOND", t)
+	validateFile(filepath.Join(dst, "run.sh"), "#!/bin/sh", t)
+	if fi, err := os.Stat(filepath.Join(dst, "run.sh")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Fatalf("Expected run.sh to keep mode 0755")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "stale.conf")); !os.IsNotExist(err) {
+		t.Fatalf("Expected stale.conf to be pruned")
+	}
+}
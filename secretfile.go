@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readSecretFile reads a secret mounted as a file, such as a Kubernetes or
+// Docker secret under /run/secrets. Missing files and files with permissions
+// beyond --file-max-mode fail. Values are masked in logs like vault values,
+// without the trailing newlines most secret files end with.
+func readSecretFile(path string) (string, error) {
+	maxMode, err := parseFileMode(config.FileMaxMode)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("Secret file %v not found", path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("Secret file %v is a directory", path)
+	}
+	if extra := info.Mode().Perm() &^ maxMode; extra != 0 {
+		return "", fmt.Errorf("Secret file %v has mode %04o, which allows more than %04o", path, info.Mode().Perm(), maxMode)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	val := string(contents)
+	redactor.Add(strings.TrimRight(val, "\r\n"))
+	if config.FileTrimNewline {
+		val = strings.TrimRight(val, "\r\n")
+	}
+
+	return val, nil
+}
+
+// parseFileMode parses an octal permission mode such as 0640. Every
+// permission is allowed when mode is empty.
+func parseFileMode(mode string) (os.FileMode, error) {
+	if len(mode) == 0 {
+		return os.ModePerm, nil
+	}
+
+	n, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || os.FileMode(n)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("Invalid file mode %q. Expected octal permissions such as 0640", mode)
+	}
+
+	return os.FileMode(n), nil
+}
+
+func fileGetString(path string) (string, error) {
+	return readSecretFile(path)
+}
+
+// dirProvider reads secrets from a directory tree laid out like vault paths.
+// A secret is either a file, or a directory holding one file per field with
+// the value field used when none is given.
+type dirProvider struct {
+	root string
+}
+
+func newDirProvider(config Config) (Provider, error) {
+	if len(config.SecretsDir) == 0 {
+		return nil, fmt.Errorf("No secrets directory. Set --secrets-dir")
+	}
+
+	return dirProvider{root: config.SecretsDir}, nil
+}
+
+func (dirProvider) Name() string { return "dir" }
+
+func (p dirProvider) Fetch(path string, field string) (string, error) {
+	filename := p.path(path)
+	if len(field) > 0 {
+		if field != filepath.Base(field) || field == "." || field == ".." {
+			return "", fmt.Errorf("Invalid field %q. Fields name a file in the secret's directory", field)
+		}
+		return readSecretFile(filepath.Join(filename, field))
+	}
+
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		filename = filepath.Join(filename, "value")
+	}
+
+	return readSecretFile(filename)
+}
+
+func (p dirProvider) List(path string) ([]string, error) {
+	return fileProvider{}.List(p.path(path))
+}
+
+func (p dirProvider) Metadata(path string) (map[string]string, error) {
+	return fileProvider{}.Metadata(p.path(path))
+}
+
+// path maps a vault-style path into the root, never outside it
+func (p dirProvider) path(path string) string {
+	return filepath.Join(p.root, filepath.FromSlash(filepath.Clean("/"+path)))
+}
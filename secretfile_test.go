@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileFunction(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+	config.FileMaxMode = "0644"
+
+	dir, err := ioutil.TempDir("", "polymerase_test_secretfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	password := writeTestFile(t, dir, "db_password", "hunter2-password\n")
+
+	tmpl, err := TemplateFromString(`[{{ file "` + password + `" }}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "[hunter2-password\n]", t)
+
+	// The value is masked without its trailing newline, even when it's kept in the output
+	if masked := redactor.Redact("password hunter2-password."); strings.Contains(masked, "hunter2") {
+		t.Fatalf("Expected the secret to be masked but got %v", masked)
+	}
+
+	config.FileTrimNewline = true
+	output = &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "[hunter2-password]", t)
+
+	// Fields selected from a file are masked on their own
+	settings := writeTestFile(t, dir, "settings.yaml", "db:\n  password: \"correct-horse-battery\"\n")
+	if _, err := providers.Fetch("file://" + settings + "#db.password"); err != nil {
+		t.Fatal(err)
+	}
+	if masked := redactor.Redact("password=correct-horse-battery"); strings.Contains(masked, "correct-horse") {
+		t.Fatalf("Expected the field to be masked but got %v", masked)
+	}
+}
+
+func TestReadSecretFile(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+	config.FileMaxMode = "0640"
+
+	dir, err := ioutil.TempDir("", "polymerase_test_secretfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := writeTestFile(t, dir, "private", "secret-private")
+	if err := os.Chmod(private, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSecretFile(private); err != nil {
+		t.Fatal(err)
+	}
+
+	public := writeTestFile(t, dir, "public", "secret-public")
+	if err := os.Chmod(public, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]string{
+		public:                        "has mode 0666, which allows more than 0640",
+		filepath.Join(dir, "missing"): "not found",
+		dir:                           "is a directory",
+	} {
+		if _, err := readSecretFile(path); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %v to fail with %q but got %v", path, expected, err)
+		}
+	}
+
+	for _, mode := range []string{"rw", "0999", "01777"} {
+		if _, err := parseFileMode(mode); err == nil {
+			t.Fatalf("Expected mode %v to be invalid", mode)
+		}
+	}
+}
+
+func TestDirProvider(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_secretfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "secret", "db"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "secret"), "api", "api-key-value\n")
+	writeTestFile(t, filepath.Join(dir, "secret", "db"), "value", "db-value\n")
+	writeTestFile(t, filepath.Join(dir, "secret", "db"), "password", "db-password\n")
+
+	// Serve vault paths from the directory tree so the same template renders without vault
+	config.SecretsDir = dir
+	config.FileTrimNewline = true
+	config.Providers = []string{"vault=dir", "env"}
+	configureVault()
+
+	tmpl, err := TemplateFromString(`{{ vault "secret/api" }} {{ secret "vault://secret/db" }} {{ secret "vault://secret/db#password" }} {{ secret "dir://secret/db#password" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err == nil || !strings.Contains(err.Error(), `Provider "dir" is not enabled`) {
+		t.Fatalf("Expected the dir provider to need enabling but got %v", err)
+	}
+
+	config.Providers = append(config.Providers, "dir")
+	providers = newProviderRegistry()
+	output = &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "api-key-value db-value db-password db-password", t)
+
+	keys, err := providers.List("vault://secret/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "password,value" {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+
+	// Paths and fields can't escape the secrets directory
+	if path := (dirProvider{root: dir}).path("../../etc/passwd"); path != filepath.Join(dir, "etc", "passwd") {
+		t.Fatalf("Expected the path to stay under %v but got %v", dir, path)
+	}
+	for _, field := range []string{"../api", "../../../etc/passwd", "..", "db/password"} {
+		if _, err := providers.Fetch("dir://secret/db#" + field); err == nil || !strings.Contains(err.Error(), "Invalid field") {
+			t.Fatalf("Expected field %v to be rejected but got %v", field, err)
+		}
+	}
+}
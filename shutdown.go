@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Exit statuses. A signal that stops polymerase exits with 128 plus the signal
+// number, as a shell would: 130 for SIGINT and 143 for SIGTERM.
+const (
+	ExitOK    = 0
+	ExitError = 1
+)
+
+// writeLock is held while files are written so shutting down never leaves a
+// destination half written or a staged file behind
+var writeLock sync.Mutex
+
+// osExit is replaced by tests
+var osExit = os.Exit
+
+var shutdownOnce sync.Once
+
+// Logger is a log.Logger whose Fatalf cleans up before exiting
+type Logger struct {
+	*log.Logger
+}
+
+// Fatalf logs like Printf and exits with ExitError once any write in progress has finished
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.Printf(format, v...)
+	exit(ExitError)
+}
+
+// exit waits for any write in progress to finish and exits with code
+func exit(code int) {
+	writeLock.Lock()
+	defer writeLock.Unlock()
+
+	osExit(code)
+}
+
+// handleShutdown exits cleanly on SIGINT or SIGTERM. In-flight vault requests
+// are abandoned, a write in progress is allowed to finish and, with
+// --revoke-leases, the leases of every secret read are revoked. A second
+// signal exits immediately. Calling it more than once has no effect.
+func handleShutdown() {
+	shutdownOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go shutdownOn(signals)
+	})
+}
+
+// shutdownOn waits for a signal on signals and shuts down as handleShutdown describes
+func shutdownOn(signals chan os.Signal) {
+	sig := <-signals
+	signal.Stop(signals)
+	logger.Printf("Received %v, shutting down", sig)
+
+	if config.RevokeLeases {
+		revokeLeases()
+	}
+	exit(128 + int(sig.(syscall.Signal)))
+}
+
+// revokeLeases revokes the leases of every secret read, if the vault client supports it
+func revokeLeases() {
+	revoker, ok := unwrapVault(vault).(LeaseRevoker)
+	if !ok {
+		return
+	}
+
+	if err := revoker.RevokeLeases(); err != nil {
+		logger.Printf("Error revoking leases: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type revokingVaultClient struct {
+	mockVaultClient
+	revoked chan bool
+}
+
+func (c revokingVaultClient) RevokeLeases() error {
+	c.revoked <- true
+	return nil
+}
+
+func stubExit() chan int {
+	codes := make(chan int, 1)
+	osExit = func(code int) { codes <- code }
+	return codes
+}
+
+func TestExitWaitsForWrites(t *testing.T) {
+	codes := stubExit()
+	defer func() { osExit = os.Exit }()
+
+	writeLock.Lock()
+	go exit(ExitChanged)
+
+	select {
+	case code := <-codes:
+		t.Fatalf("Expected exit to wait for the write but it exited with %v", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeLock.Unlock()
+	select {
+	case code := <-codes:
+		if code != ExitChanged {
+			t.Fatalf("Expected exit status %v but got %v", ExitChanged, code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected exit once the write finished")
+	}
+}
+
+func TestHandleShutdown(t *testing.T) {
+	codes := stubExit()
+	defer func() { osExit = os.Exit }()
+
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+	config.RevokeLeases = true
+	revoked := make(chan bool, 1)
+	vault = newCachingVault(revokingVaultClient{revoked: revoked})
+
+	signals := make(chan os.Signal, 1)
+	go shutdownOn(signals)
+	signals <- syscall.SIGTERM
+
+	select {
+	case code := <-codes:
+		if code != 128+int(syscall.SIGTERM) {
+			t.Fatalf("Expected exit status %v but got %v", 128+int(syscall.SIGTERM), code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected SIGTERM to exit")
+	}
+
+	select {
+	case <-revoked:
+	default:
+		t.Fatal("Expected leases to be revoked")
+	}
+}
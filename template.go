@@ -1,12 +1,32 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"text/template"
 )
 
+const (
+	// TemplateSuffix marks files that are rendered rather than copied by render-dir
+	TemplateSuffix = ".tmpl"
+	// PartialPrefix marks template files that are only included by other templates
+	PartialPrefix = "_"
+)
+
+// delimsDirective sets the delimiters for a single file when found on its first line,
+// e.g. "# polymerase:delims [[ ]]"
+var delimsDirective = regexp.MustCompile(`polymerase:delims\s+(\S+)\s+(\S+)`)
+
+// refreshDirective sets how often watch polls the vault secrets of a single
+// file when found on its first line, e.g. "# polymerase:refresh 5m" or
+// "# polymerase:refresh lease"
+var refreshDirective = regexp.MustCompile(`polymerase:refresh\s+(\S+)`)
+
 // Template suitable for executing
 type Template interface {
 	Execute(io.Writer, interface{}) error
@@ -35,12 +55,149 @@ func TemplateFromReader(in io.Reader) (Template, error) {
 	return TemplateFromString(str)
 }
 
-// TemplateFromString returns a new template created by parsing a string
+// TemplateFromString returns a new template created by parsing a string along
+// with any partials found in the configured include paths
 func TemplateFromString(str string) (Template, error) {
-	return newConcreteTemplate("str").Parse(str)
+	tmpl, err := parseTemplate("str", str, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return concreteTemplate{tmpl}, nil
+}
+
+// parseTemplate parses str and the configured partials into a template set
+// named name. Functions in extra are added to the set, replacing any builtins.
+func parseTemplate(name string, str string, extra template.FuncMap) (*template.Template, error) {
+	if err := config.validateDelims(); err != nil {
+		return nil, err
+	}
+
+	tmpl, err := parseWithDirectives(newConcreteTemplate(name).Funcs(extra), str)
+	if err != nil {
+		return nil, err
+	}
+
+	partials, err := PartialFiles(config.Includes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range partials {
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		// Partials start from the configured delimiters rather than those of
+		// the template including them
+		partial := tmpl.New(filepath.Base(filename)).Delims(config.LeftDelim, config.RightDelim)
+		if _, err := parseWithDirectives(partial, string(contents)); err != nil {
+			return nil, err
+		}
+	}
+
+	return tmpl, nil
+}
+
+// concreteTemplate is a parsed text/template that runs its checks when executed
+type concreteTemplate struct {
+	*template.Template
+}
+
+// Execute applies the template to data. Before anything is written, strict
+// mode reports every undefined environment variable the template references
+// and, unless --skip-capability-check is set, the vault token's capabilities
+// are checked for every known path.
+func (t concreteTemplate) Execute(w io.Writer, data interface{}) error {
+	if config.Strict {
+		if undefined := UndefinedEnvKeys(t.Template, data); len(undefined) > 0 {
+			return fmt.Errorf("Undefined variables referenced: %v", strings.Join(undefined, ", "))
+		}
+	}
+
+	if !config.SkipCapabilities {
+		if err := CheckCapabilities(vault, TemplateReferences(t.Template).VaultPaths); err != nil {
+			return err
+		}
+	}
+
+	return t.Template.Execute(w, data)
+}
+
+// PartialFiles resolves include paths to partial template files. Directories
+// contribute every _*.tmpl file they contain and anything else is treated as a glob.
+func PartialFiles(includes []string) ([]string, error) {
+	var files []string
+	for _, include := range includes {
+		pattern := include
+		fi, err := os.Stat(include)
+		isDir := err == nil && fi.IsDir()
+		if isDir {
+			pattern = filepath.Join(include, PartialPrefix+"*"+TemplateSuffix)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid include pattern %q: %v", include, err)
+		}
+		if len(matches) == 0 && !isDir {
+			return nil, fmt.Errorf("No partials match include %q", include)
+		}
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+// IsPartial reports whether filename names a partial template
+func IsPartial(filename string) bool {
+	base := filepath.Base(filename)
+	return strings.HasPrefix(base, PartialPrefix) && strings.HasSuffix(base, TemplateSuffix)
+}
+
+// parseWithDirectives parses str into tmpl after applying and stripping any
+// directive header on its first line
+func parseWithDirectives(tmpl *template.Template, str string) (*template.Template, error) {
+	header, body := str, ""
+	if i := strings.Index(str, "\n"); i >= 0 {
+		header, body = str[:i], str[i+1:]
+	}
+
+	if m := delimsDirective.FindStringSubmatch(header); m != nil {
+		tmpl.Delims(m[1], m[2])
+	}
+	if isDirectiveHeader(header) {
+		str = body
+	}
+
+	return tmpl.Parse(str)
+}
+
+// isDirectiveHeader reports whether the first line of a template holds directives
+func isDirectiveHeader(header string) bool {
+	return delimsDirective.MatchString(header) || refreshDirective.MatchString(header)
 }
 
 func newConcreteTemplate(tplName string) *template.Template {
-	funcMap := template.FuncMap{"vault": vaultGetString}
-	return template.New(tplName).Funcs(funcMap)
+	funcMap := builtinFuncs()
+	funcMap["vault"] = vaultGetString
+	funcMap["env"] = envGetString
+	funcMap["requiredEnv"] = requiredEnvGetString
+	funcMap["hasEnv"] = hasEnv
+	funcMap["consul"] = consulGetString
+	funcMap["consulTree"] = consulGetTree
+	funcMap["etcd"] = etcdGetString
+	funcMap["etcdTree"] = etcdGetTree
+	funcMap["file"] = fileGetString
+	funcMap["secret"] = secretGetString
+	funcMap["secretList"] = secretList
+	funcMap["secretMeta"] = secretMetadata
+
+	tmpl := template.New(tplName).Delims(config.LeftDelim, config.RightDelim).Funcs(funcMap)
+	if config.Strict {
+		tmpl.Option("missingkey=error")
+	}
+
+	return tmpl
 }
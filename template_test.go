@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartials(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	vault = context.mockVault
+
+	dir, err := ioutil.TempDir("", "polymerase_test_partials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "_database.tmpl", "{{ define \"database\" }}user={{ vault \"secret/db/user\" }}{{ end }}")
+	writeTestFile(t, dir, "_greeting.tmpl", "hello")
+	writeTestFile(t, dir, "service.tmpl", "not a partial")
+	config.Includes = []string{dir}
+
+	tmpl, err := TemplateFromString("{{ template \"database\" }} {{ template \"_greeting.tmpl\" }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "user=BOND hello", t)
+}
+
+func TestPartialFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_partials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	partial := writeTestFile(t, dir, "_a.tmpl", "")
+	shared := writeTestFile(t, dir, "shared.tpl", "")
+	writeTestFile(t, dir, "b.tmpl", "")
+
+	files, err := PartialFiles([]string{dir, filepath.Join(dir, "*.tpl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != partial || files[1] != shared {
+		t.Fatalf("Unexpected partials %v", files)
+	}
+
+	if _, err := PartialFiles([]string{filepath.Join(dir, "*.missing")}); err == nil {
+		t.Fatalf("Expected an error for an include matching nothing")
+	}
+}
+
+func TestDelims(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	vault = context.mockVault
+
+	dir, err := ioutil.TempDir("", "polymerase_test_partials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "_alert.tmpl", "# polymerase:delims <% %>\n{{ $labels.job }} <% .NAME %>")
+	config.Includes = []string{dir}
+	config.LeftDelim, config.RightDelim = "[[", "]]"
+
+	tmpl, err := TemplateFromString("{{ .Values }} [[ vault \"secret/name\" ]] [[ template \"_alert.tmpl\" . ]]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, map[string]string{"NAME": "JAMES"}); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "{{ .Values }} BOND {{ $labels.job }} JAMES", t)
+
+	tmpl, err = TemplateFromString("<!-- polymerase:delims (( )) -->\n{{ x }} (( .NAME ))")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output = &bytes.Buffer{}
+	if err := tmpl.Execute(output, map[string]string{"NAME": "JAMES"}); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "{{ x }} JAMES", t)
+
+	// A directive in the including template doesn't apply to its partials
+	config.LeftDelim, config.RightDelim = "", ""
+	writeTestFile(t, dir, "_alert.tmpl", "{{ .NAME }} <% .NAME %>")
+	tmpl, err = TemplateFromString("# polymerase:delims <% %>\n<% template \"_alert.tmpl\" . %> {{ .NAME }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output = &bytes.Buffer{}
+	if err := tmpl.Execute(output, map[string]string{"NAME": "JAMES"}); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "JAMES <% .NAME %> {{ .NAME }}", t)
+
+	config.LeftDelim = "[["
+	if _, err := TemplateFromString("[[ .NAME ]]"); err == nil || !strings.Contains(err.Error(), "Invalid template delimiters") {
+		t.Fatalf("Expected a half-set delimiter pair to fail but got %v", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	config.Strict = true
+
+	tmpl, err := TemplateFromString("{{ .FIRST_NAME }} {{ .LAST_NAME }}{{ with .TITLE }}{{ .Ignored }}{{ end }}{{ $.Env.MIDDLE_NAME }}{{ .Data.ignored }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tmpl.Execute(&bytes.Buffer{}, map[string]string{"FIRST_NAME": "JAMES"})
+	if err == nil || err.Error() != "Undefined variables referenced: LAST_NAME, MIDDLE_NAME, TITLE" {
+		t.Fatalf("Expected undefined variables to be reported but got %v", err)
+	}
+
+	output := &bytes.Buffer{}
+	environment := map[string]string{"FIRST_NAME": "JAMES", "LAST_NAME": "BOND", "TITLE": "", "MIDDLE_NAME": "HERBERT"}
+	data := map[string]interface{}{"FIRST_NAME": "JAMES", "LAST_NAME": "BOND", "TITLE": "", "Env": environment, "Data": map[string]interface{}{"ignored": 1}}
+	if err := tmpl.Execute(output, data); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "JAMES BONDHERBERT1", t)
+}
+
+func TestEnvFunctions(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	os.Setenv("FIRST_NAME", "JAMES")
+	os.Unsetenv("POLYMERASE_TEST_UNSET")
+
+	tmpl, err := TemplateFromString("{{ env \"FIRST_NAME\" \"M\" }} {{ env \"POLYMERASE_TEST_UNSET\" \"Q\" }} {{ requiredEnv \"FIRST_NAME\" }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Execute(output, nil); err != nil {
+		t.Fatal(err)
+	}
+	validateOutput(output, "JAMES Q JAMES", t)
+
+	tmpl, err = TemplateFromString("{{ requiredEnv \"POLYMERASE_TEST_UNSET\" }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, nil); err == nil {
+		t.Fatalf("Expected requiredEnv to fail for an unset variable")
+	}
+}
@@ -1,12 +1,55 @@
 package main
 
-import "github.com/dollarshaveclub/polymerase/pkg/vaultclient"
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dollarshaveclub/polymerase/pkg/vaultclient"
+)
 
 // Vault is a simple interface for a vault client
 type Vault interface {
 	GetStringValue(string) (string, error)
 }
 
+// PolicyWriter is implemented by vault clients that can write ACL policies
+type PolicyWriter interface {
+	PutPolicy(name string, rules string) error
+}
+
+// CapabilityChecker is implemented by vault clients that can report the
+// capabilities of their own token on a path
+type CapabilityChecker interface {
+	CapabilitiesSelf(path string) ([]string, error)
+}
+
+// LeaseReader is implemented by vault clients that can report the lease
+// duration of a secret
+type LeaseReader interface {
+	LeaseDuration(path string) (time.Duration, error)
+}
+
+// LeaseRevoker is implemented by vault clients that can revoke the leases of
+// the secrets they have read
+type LeaseRevoker interface {
+	RevokeLeases() error
+}
+
+// FieldReader is implemented by vault clients that can read fields of a
+// secret other than value
+type FieldReader interface {
+	GetStringField(path string, field string) (string, error)
+}
+
+// KeyLister is implemented by vault clients that can list the keys under a path
+type KeyLister interface {
+	ListKeys(path string) ([]string, error)
+}
+
 // AuthenticatedVaultClient creates and authenicates a vault client using the given config
 func AuthenticatedVaultClient(config Config) (Vault, error) {
 
@@ -23,3 +66,132 @@ func AuthenticatedVaultClient(config Config) (Vault, error) {
 
 	return v, err
 }
+
+// providerVault reads vault paths through whichever provider --provider
+// serves vault:// URIs with, failing if there is none
+type providerVault struct{}
+
+func (providerVault) GetStringValue(path string) (string, error) {
+	return providers.Fetch("vault://" + path)
+}
+
+// cachingVault memoizes values so each path is fetched from vault at most once
+// per run. It is safe to use from several goroutines.
+type cachingVault struct {
+	vault  Vault
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newCachingVault(v Vault) *cachingVault {
+	return &cachingVault{vault: v, values: make(map[string]string)}
+}
+
+// GetStringValue returns the cached value for path, fetching it on first use
+func (c *cachingVault) GetStringValue(path string) (string, error) {
+	c.mu.Lock()
+	val, ok := c.values[path]
+	c.mu.Unlock()
+	if ok {
+		return val, nil
+	}
+
+	val, err := c.vault.GetStringValue(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.values[path] = val
+	c.mu.Unlock()
+
+	return val, nil
+}
+
+// set replaces the cached value of path
+func (c *cachingVault) set(path string, val string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[path] = val
+}
+
+// Clear empties the cache so every path is fetched from vault again
+func (c *cachingVault) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = make(map[string]string)
+}
+
+// unwrapVault returns the client behind a cachingVault, so optional
+// interfaces can be checked on it
+func unwrapVault(v Vault) Vault {
+	if c, ok := v.(*cachingVault); ok {
+		return c.vault
+	}
+
+	return v
+}
+
+// requiredCapabilities are the capabilities rendering needs on every vault path
+var requiredCapabilities = []string{"read"}
+
+// CheckCapabilities verifies that v may read every path, reporting all missing
+// permissions at once. Clients and servers that can't report capabilities aren't checked.
+func CheckCapabilities(v Vault, paths []string) error {
+	checker, ok := unwrapVault(v).(CapabilityChecker)
+	if !ok || len(paths) == 0 {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tCAPABILITIES\tMISSING")
+
+	missing := 0
+	for _, path := range paths {
+		caps, err := checker.CapabilitiesSelf(path)
+		if err == vaultclient.ErrCapabilitiesUnsupported {
+			logger.Printf("Skipping the permission check: %v", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if lacking := missingCapabilities(caps); len(lacking) > 0 {
+			missing++
+			found := strings.Join(caps, ",")
+			if len(caps) == 0 {
+				found = "none"
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", path, found, strings.Join(lacking, ","))
+		}
+	}
+	w.Flush()
+
+	if missing > 0 {
+		return fmt.Errorf("Missing vault permissions on %v paths:\n%v", missing, buf.String())
+	}
+
+	return nil
+}
+
+// missingCapabilities returns the required capabilities that caps lacks. Root grants them all.
+func missingCapabilities(caps []string) []string {
+	has := make(map[string]bool, len(caps))
+	for _, c := range caps {
+		has[c] = true
+	}
+	if has["root"] {
+		return nil
+	}
+
+	var missing []string
+	for _, c := range requiredCapabilities {
+		if !has[c] {
+			missing = append(missing, c)
+		}
+	}
+
+	return missing
+}
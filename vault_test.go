@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/polymerase/pkg/vaulttest"
+)
+
+type mockCapabilityVaultClient struct {
+	mockVaultClient
+	capabilities map[string][]string
+}
+
+func (c mockCapabilityVaultClient) CapabilitiesSelf(path string) ([]string, error) {
+	return c.capabilities[path], nil
+}
+
+func TestCapabilityCheck(t *testing.T) {
+	context := newTestContext("BOND", "", &bytes.Buffer{})
+	setupTest(context)
+	vault = newCachingVault(mockCapabilityVaultClient{
+		mockVaultClient: *context.mockVault,
+		capabilities: map[string][]string{
+			"secret/readable": {"read", "list"},
+			"secret/root":     {"root"},
+			"secret/denied":   {"deny"},
+			"secret/listable": {"list"},
+		},
+	})
+
+	tmpl, err := TemplateFromString("{{ vault \"secret/readable\" }} {{ vault \"secret/root\" }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	output := &bytes.Buffer{}
+	tmpl, err = TemplateFromString("{{ vault \"secret/readable\" }} {{ vault \"secret/denied\" }} {{ vault \"secret/listable\" }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tmpl.Execute(output, nil)
+	if err == nil {
+		t.Fatalf("Expected missing capabilities to be reported")
+	}
+	for _, expected := range []string{"on 2 paths", "secret/denied    deny          read", "secret/listable  list          read"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %q in error %v", expected, err)
+		}
+	}
+	if output.Len() != 0 {
+		t.Fatalf("Expected nothing to be rendered but got %v", output.String())
+	}
+
+	// Paths read through secret URIs are checked too
+	secretTmpl, err := TemplateFromString(`{{ secret "vault://secret/denied#password" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := secretTmpl.Execute(&bytes.Buffer{}, nil); err == nil || !strings.Contains(err.Error(), "secret/denied") {
+		t.Fatalf("Expected the denied secret URI to be reported but got %v", err)
+	}
+
+	config.SkipCapabilities = true
+	if err := tmpl.Execute(&bytes.Buffer{}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type countingVaultClient struct {
+	fetches map[string]int
+}
+
+func (c countingVaultClient) GetStringValue(path string) (string, error) {
+	c.fetches[path]++
+	return path, nil
+}
+
+func TestCachingVault(t *testing.T) {
+	counter := countingVaultClient{fetches: make(map[string]int)}
+	v := newCachingVault(counter)
+
+	for i := 0; i < 3; i++ {
+		if val, err := v.GetStringValue("secret/a"); err != nil || val != "secret/a" {
+			t.Fatalf("Unexpected value %v, %v", val, err)
+		}
+	}
+	if counter.fetches["secret/a"] != 1 {
+		t.Fatalf("Expected one fetch but got %v", counter.fetches["secret/a"])
+	}
+}
+
+func TestVaultServer(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.Write("secret/app/name", map[string]interface{}{"value": "server-name"})
+	server.WriteSecret("database/creds/app", vaulttest.Secret{Data: map[string]interface{}{"value": "leased-password"}, LeaseDuration: time.Hour})
+
+	output := &bytes.Buffer{}
+	context := newTestContext("", "", output)
+	setupTest(context)
+	config.VaultAddr = server.URL
+	config.VaultToken = vaulttest.RootToken
+	config.VaultFactoryFunc = AuthenticatedVaultClient
+
+	dir, err := ioutil.TempDir("", "polymerase_test_vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := writeTestFile(t, dir, "app.tmpl", `{{ vault "secret/app/name" }} {{ vault "database/creds/app" }}`)
+
+	run(rootCmd, []string{filename})
+	validateOutput(output, "server-name leased-password", t)
+
+	revokeLeases()
+	if revoked := server.Revoked(); len(revoked) != 1 {
+		t.Fatalf("Expected the lease to be revoked but got %v", revoked)
+	}
+
+	server.SetCapabilities("secret/app/name", "deny")
+	tmpl, err := TemplateFromString(`{{ vault "secret/app/name" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, nil); err == nil || !strings.Contains(err.Error(), "Missing vault permissions on 1 paths") {
+		t.Fatalf("Expected the denied path to be reported but got %v", err)
+	}
+
+	// Servers without the capabilities endpoint skip the check
+	server.Fail("sys/capabilities-self", 404, 0)
+	if err := tmpl.Execute(&bytes.Buffer{}, nil); err != nil {
+		t.Fatalf("Expected the check to be skipped but got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// visitFunc is called for every node reachable from a template. root reports
+// whether the node refers to the data passed to Execute: for variables that
+// means $ is still that data, for everything else that dot is.
+type visitFunc func(node parse.Node, root bool)
+
+type walker struct {
+	tmpl  *template.Template
+	visit visitFunc
+	seen  map[string]bool
+}
+
+// walkTemplate visits every node in tmpl, following {{ template }} calls into
+// the other templates of its set
+func walkTemplate(tmpl *template.Template, visit visitFunc) {
+	w := walker{tmpl: tmpl, visit: visit, seen: make(map[string]bool)}
+	w.walkTemplate(tmpl.Name(), true)
+}
+
+func (w walker) walkTemplate(name string, root bool) {
+	key := name
+	if root {
+		key += "\x00root"
+	}
+	if w.seen[key] {
+		return
+	}
+	w.seen[key] = true
+
+	if t := w.tmpl.Lookup(name); t != nil && t.Tree != nil {
+		w.walk(t.Tree.Root, root, root)
+	}
+}
+
+func (w walker) walk(node parse.Node, root bool, tmplRoot bool) {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return
+	}
+
+	if node.Type() == parse.NodeVariable {
+		w.visit(node, tmplRoot)
+	} else {
+		w.visit(node, root)
+	}
+
+	switch node := node.(type) {
+	case *parse.ListNode:
+		for _, n := range node.Nodes {
+			w.walk(n, root, tmplRoot)
+		}
+	case *parse.ActionNode:
+		w.walk(node.Pipe, root, tmplRoot)
+	case *parse.PipeNode:
+		for _, cmd := range node.Cmds {
+			w.walk(cmd, root, tmplRoot)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			w.walk(arg, root, tmplRoot)
+		}
+	case *parse.ChainNode:
+		w.walk(node.Node, root, tmplRoot)
+	case *parse.IfNode:
+		w.walkBranch(&node.BranchNode, root, root, tmplRoot)
+	case *parse.RangeNode:
+		w.walkBranch(&node.BranchNode, false, root, tmplRoot)
+	case *parse.WithNode:
+		w.walkBranch(&node.BranchNode, false, root, tmplRoot)
+	case *parse.TemplateNode:
+		w.walk(node.Pipe, root, tmplRoot)
+		w.walkTemplate(node.Name, passesRoot(node.Pipe, root, tmplRoot))
+	}
+}
+
+func (w walker) walkBranch(node *parse.BranchNode, bodyRoot bool, elseRoot bool, tmplRoot bool) {
+	w.walk(node.Pipe, elseRoot, tmplRoot)
+	w.walk(node.List, bodyRoot, tmplRoot)
+	w.walk(node.ElseList, elseRoot, tmplRoot)
+}
+
+// passesRoot reports whether a {{ template }} call passes along the data given to Execute
+func passesRoot(pipe *parse.PipeNode, root bool, tmplRoot bool) bool {
+	if pipe == nil || len(pipe.Decl) != 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return root
+	case *parse.VariableNode:
+		return tmplRoot && len(arg.Ident) == 1 && arg.Ident[0] == "$"
+	}
+
+	return false
+}
+
+// rootPath returns the chain of fields a node reads from the data passed to Execute, if any
+func rootPath(node parse.Node, root bool) ([]string, bool) {
+	switch node := node.(type) {
+	case *parse.FieldNode:
+		if root {
+			return node.Ident, true
+		}
+	case *parse.VariableNode:
+		if root && len(node.Ident) > 1 && node.Ident[0] == "$" {
+			return node.Ident[1:], true
+		}
+	}
+
+	return nil, false
+}
+
+// envKeyFromPath returns the environment variable a field chain reads, either
+// as a top-level key such as .HOME or through .Env.HOME
+func envKeyFromPath(path []string) (string, bool) {
+	if !isContextKey(path[0]) {
+		return path[0], true
+	}
+	if path[0] == contextEnv && len(path) > 1 {
+		return path[1], true
+	}
+
+	return "", false
+}
+
+// TemplateEnvKeys returns the sorted environment variables a template reads as fields
+func TemplateEnvKeys(tmpl *template.Template) []string {
+	seen := make(map[string]bool)
+	walkTemplate(tmpl, func(node parse.Node, root bool) {
+		if path, ok := rootPath(node, root); ok {
+			if key, ok := envKeyFromPath(path); ok {
+				seen[key] = true
+			}
+		}
+	})
+
+	return sortedKeys(seen)
+}
+
+// UndefinedEnvKeys returns the environment variables a template reads that are missing from data
+func UndefinedEnvKeys(tmpl *template.Template, data interface{}) []string {
+	environment, ok := contextEnvironment(data)
+	if !ok {
+		return nil
+	}
+
+	var undefined []string
+	for _, key := range TemplateEnvKeys(tmpl) {
+		if _, ok := environment[key]; !ok {
+			undefined = append(undefined, key)
+		}
+	}
+
+	return undefined
+}
+
+// FuncRef is a call to a template function. Arg holds the first argument when
+// it is a string literal, otherwise Dynamic is set.
+type FuncRef struct {
+	Func    string
+	Arg     string
+	Dynamic bool
+}
+
+// TemplateFuncRefs returns every call a template makes to the named functions,
+// whether written as {{ vault "path" }} or {{ "path" | vault }}
+func TemplateFuncRefs(tmpl *template.Template, funcs ...string) []FuncRef {
+	wanted := make(map[string]bool, len(funcs))
+	for _, name := range funcs {
+		wanted[name] = true
+	}
+
+	var refs []FuncRef
+	walkTemplate(tmpl, func(node parse.Node, root bool) {
+		pipe, ok := node.(*parse.PipeNode)
+		if !ok {
+			return
+		}
+
+		for i, cmd := range pipe.Cmds {
+			ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+			if !ok || !wanted[ident.Ident] {
+				continue
+			}
+
+			var arg parse.Node
+			if len(cmd.Args) > 1 {
+				arg = cmd.Args[1]
+			} else if i > 0 && len(pipe.Cmds[i-1].Args) == 1 {
+				arg = pipe.Cmds[i-1].Args[0]
+			}
+
+			ref := FuncRef{Func: ident.Ident, Dynamic: true}
+			if str, ok := arg.(*parse.StringNode); ok {
+				ref.Arg, ref.Dynamic = str.Text, false
+			}
+			refs = append(refs, ref)
+		}
+	})
+
+	return refs
+}
+
+// References are the environment variables and vault paths a template
+// reads. VaultFields holds the fields read from each vault path. Dynamic lists
+// the functions called with computed arguments, whose references can't be
+// known until the template is executed.
+type References struct {
+	EnvVars     []string
+	VaultPaths  []string
+	VaultFields map[string][]string
+	Dynamic     []string
+}
+
+// TemplateReferences returns the sorted environment variables and vault paths a
+// template reads, through fields as well as the env, vault and secret functions
+func TemplateReferences(tmpl *template.Template) References {
+	envVars := make(map[string]bool)
+	for _, key := range TemplateEnvKeys(tmpl) {
+		envVars[key] = true
+	}
+
+	var refs References
+	vaultFields := make(map[string]map[string]bool)
+	addVault := func(path string, field string) {
+		if vaultFields[path] == nil {
+			vaultFields[path] = make(map[string]bool)
+		}
+		vaultFields[path][field] = true
+	}
+	for _, ref := range TemplateFuncRefs(tmpl, "vault", "secret", "env", "requiredEnv", "hasEnv") {
+		switch {
+		case ref.Dynamic:
+			refs.Dynamic = append(refs.Dynamic, ref.Func)
+		case ref.Func == "vault":
+			addVault(ref.Arg, vaultValueField)
+		case ref.Func == "secret":
+			if path, field, ok := vaultSecretURI(ref.Arg); ok {
+				addVault(path, field)
+			}
+		default:
+			envVars[ref.Arg] = true
+		}
+	}
+
+	refs.EnvVars = sortedKeys(envVars)
+	refs.VaultFields = make(map[string][]string, len(vaultFields))
+	for path, fields := range vaultFields {
+		refs.VaultPaths = append(refs.VaultPaths, path)
+		refs.VaultFields[path] = sortedKeys(fields)
+	}
+	sort.Strings(refs.VaultPaths)
+
+	return refs
+}
+
+// vaultSecretURI returns the vault path and field a secret URI reads, if
+// --provider serves its scheme with the vault provider
+func vaultSecretURI(uri string) (string, string, bool) {
+	name, path, field, err := parseSecretURI(uri)
+	if err != nil {
+		return "", "", false
+	}
+	if impl, enabled := providerImpl(config.Providers, name); !enabled || impl != "vault" {
+		return "", "", false
+	}
+	if len(field) == 0 {
+		field = vaultValueField
+	}
+
+	return path, field, true
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [filename]",
+	Short: "Re-render templates whenever their inputs change",
+	Long: "Renders templates and then re-renders them whenever a template, partial, --data file or --env-file changes, " +
+		"or with --refresh whenever a vault secret they reference changes. Destinations are only rewritten when their content changes.",
+	Example: "polymerase watch --output /etc/app.conf app.conf.tmpl\npolymerase watch -T nginx.conf.tmpl:/etc/nginx/nginx.conf --data values.yaml",
+	Run:     runWatch,
+}
+
+func init() {
+	watchCmd.Flags().DurationVar(&config.Debounce, "debounce", 250*time.Millisecond, "How long to wait for changes to settle before re-rendering.")
+	watchCmd.Flags().DurationVar(&config.Refresh, "refresh", 0, "How often to poll vault for changed secrets. A template's polymerase:refresh directive overrides it. 0 disables polling.")
+	addRenderFlags(watchCmd.Flags())
+	rootCmd.AddCommand(watchCmd)
+}
+
+func runWatch(cmd *cobra.Command, args []string) {
+	pairs, err := watchPairs(args)
+	if err != nil {
+		logger.Fatalf("Error reading template pairs: %v", err)
+	}
+	if len(pairs) == 0 {
+		cmd.Usage()
+		return
+	}
+
+	hooks, err := newHookRunner(destinations(pairs))
+	if err != nil {
+		logger.Fatalf("Error reading hooks: %v", err)
+	}
+
+	if _, err := config.Validate(); err != nil {
+		logger.Fatalf("Error validating config: %v", err)
+	}
+
+	handleShutdown()
+	configureVault()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	w := &Watcher{
+		Inputs:   func() ([]string, error) { return watchInputs(pairs) },
+		Debounce: config.Debounce,
+		Render: func() error {
+			changed, err := renderChanged(pairs)
+			if err != nil {
+				return err
+			}
+			if err := hooks.Run(changed); err != nil {
+				return StopError{fmt.Errorf("Error running hook: %v", err)}
+			}
+			return nil
+		},
+		Refresher: &Refresher{Vault: vault.(*cachingVault), Pairs: pairs, Interval: config.Refresh},
+		Reload:    reload,
+	}
+	if err := w.Run(make(chan struct{})); err != nil {
+		logger.Fatalf("Error watching templates: %v", err)
+	}
+}
+
+// watchPairs returns the pairs given by --template and --manifest, plus the
+// filename argument rendered to --output
+func watchPairs(args []string) ([]TemplatePair, error) {
+	pairs, err := templatePairs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 1 || (len(args) == 1) != (len(config.OutputFile) > 0) {
+		return nil, nil
+	}
+	if len(args) == 1 {
+		pairs = append(pairs, TemplatePair{Source: args[0], Destination: config.OutputFile})
+	}
+
+	return pairs, nil
+}
+
+// watchInputs returns every file and include directory the pairs are rendered from
+func watchInputs(pairs []TemplatePair) ([]string, error) {
+	partials, err := PartialFiles(config.Includes)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []string
+	for _, pair := range pairs {
+		inputs = append(inputs, pair.Source)
+	}
+	if len(config.Manifest) > 0 {
+		inputs = append(inputs, config.Manifest)
+	}
+	inputs = append(inputs, partials...)
+	inputs = append(inputs, config.Includes...)
+	inputs = append(inputs, config.DataFiles...)
+	inputs = append(inputs, config.EnvFiles...)
+
+	return inputs, nil
+}
+
+// renderChanged renders every pair and rewrites only the destinations whose
+// content changed, returning those files
+func renderChanged(pairs []TemplatePair) ([]RenderedFile, error) {
+	data, err := templateContext()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := RenderPairs(pairs, data)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := ChangedFiles(PairFiles(pairs, outputs))
+	if err := WriteFiles(changed); err != nil {
+		return nil, err
+	}
+
+	for _, file := range changed {
+		logger.Printf("Rendered %v", file.Path)
+	}
+
+	return changed, nil
+}
+
+// Watcher calls Render once and then again whenever one of its inputs changes.
+// Bursts of events are coalesced into a single render once no event has arrived for Debounce.
+// If Refresher is set, Render is also called whenever it finds changed secrets.
+// A value received on Reload forces an immediate render with freshly fetched secrets.
+type Watcher struct {
+	Inputs    func() ([]string, error)
+	Debounce  time.Duration
+	Render    func() error
+	Refresher *Refresher
+	Reload    <-chan os.Signal
+}
+
+// StopError is a Render error that stops the watcher instead of being logged
+type StopError struct {
+	Err error
+}
+
+func (e StopError) Error() string { return e.Err.Error() }
+
+// Run watches until stop is closed. Render errors are logged rather than
+// returned so a bad edit doesn't stop the watcher, unless they are a StopError.
+func (w *Watcher) Run(stop <-chan struct{}) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsw.Close()
+
+	inputs, err := w.watch(fsw, nil)
+	if err != nil {
+		return err
+	}
+	if err := w.render(); err != nil {
+		return err
+	}
+
+	var debounce <-chan time.Time
+	refresh := w.refreshTimer()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-fsw.Errors:
+			logger.Printf("Error watching templates: %v", err)
+		case event := <-fsw.Events:
+			if isInput(inputs, event.Name) {
+				debounce = time.After(w.Debounce)
+			}
+		case sig := <-w.Reload:
+			logger.Printf("Received %v, re-rendering", sig)
+			if w.Refresher != nil {
+				w.Refresher.Vault.Clear()
+			}
+			debounce = time.After(0)
+		case <-debounce:
+			debounce = nil
+			// Inputs may have changed, e.g. a new partial or an edited manifest
+			if inputs, err = w.watch(fsw, inputs); err != nil {
+				logger.Printf("Error watching templates: %v", err)
+			}
+			if err := w.render(); err != nil {
+				return err
+			}
+			refresh = w.refreshTimer()
+		case <-refresh:
+			changed, err := w.Refresher.Poll(time.Now())
+			if err != nil {
+				logger.Printf("Error refreshing secrets: %v", err)
+			}
+			if changed {
+				if err := w.render(); err != nil {
+					return err
+				}
+			}
+			refresh = w.refreshTimer()
+		}
+	}
+}
+
+// render calls Render, logging its error and returning only a StopError
+func (w *Watcher) render() error {
+	// Values read with the secret function aren't polled, so fetch them afresh on every render
+	providers.Clear()
+	if err := w.Render(); err != nil {
+		if stop, ok := err.(StopError); ok {
+			return stop
+		}
+		logger.Printf("Error rendering templates: %v", err)
+	}
+
+	if w.Refresher != nil {
+		if err := w.Refresher.Reset(time.Now()); err != nil {
+			logger.Printf("Error refreshing secrets: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// refreshTimer fires when the Refresher is next due, or never without one
+func (w *Watcher) refreshTimer() <-chan time.Time {
+	if w.Refresher == nil {
+		return nil
+	}
+
+	next := w.Refresher.Next()
+	if next.IsZero() {
+		return nil
+	}
+
+	return time.After(next.Sub(time.Now()))
+}
+
+// watch adds the directory of every input to fsw. Watching directories
+// rather than files keeps working when editors replace a file by renaming a
+// new one over it. It returns the set of inputs to filter events by.
+func (w *Watcher) watch(fsw *fsnotify.Watcher, previous map[string]bool) (map[string]bool, error) {
+	names, err := w.Inputs()
+	if err != nil {
+		return previous, err
+	}
+
+	inputs := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = filepath.Clean(name)
+		inputs[name] = true
+
+		dir := filepath.Dir(name)
+		if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+			dir = name
+		}
+		if err := fsw.Add(dir); err != nil {
+			return previous, err
+		}
+	}
+
+	return inputs, nil
+}
+
+// isInput reports whether name is one of inputs, is inside an input
+// directory, or matches an input glob
+func isInput(inputs map[string]bool, name string) bool {
+	name = filepath.Clean(name)
+	if inputs[name] || inputs[filepath.Dir(name)] {
+		return true
+	}
+
+	for input := range inputs {
+		if matched, _ := filepath.Match(input, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
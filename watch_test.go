@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRenderChanged(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+
+	dir, err := ioutil.TempDir("", "polymerase_test_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := writeTestFile(t, dir, "app.env.tmpl", "NAME={{ .NAME }}\n")
+	pairs := []TemplatePair{{Source: src, Destination: filepath.Join(dir, "app.env")}}
+	os.Setenv("NAME", "polymerase")
+
+	changed, err := renderChanged(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 {
+		t.Fatalf("Expected 1 changed file but got %v", len(changed))
+	}
+	validateFile(pairs[0].Destination, "NAME=polymerase\n", t)
+
+	changed, err = renderChanged(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("Expected unchanged output not to be rewritten but got %v", changed)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := writeTestFile(t, dir, "app.env.tmpl", "ONE")
+	renders := make(chan struct{}, 10)
+	w := &Watcher{
+		Inputs:   func() ([]string, error) { return []string{src}, nil },
+		Debounce: 50 * time.Millisecond,
+		Render: func() error {
+			renders <- struct{}{}
+			return nil
+		},
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() { errs <- w.Run(stop) }()
+	defer func() {
+		close(stop)
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	expectRender := func(expected bool) {
+		select {
+		case <-renders:
+			if !expected {
+				t.Fatal("Expected no render")
+			}
+		case <-time.After(500 * time.Millisecond):
+			if expected {
+				t.Fatal("Expected a render")
+			}
+		}
+	}
+	expectRender(true)
+
+	// Files that aren't inputs are ignored
+	writeTestFile(t, dir, "unrelated", "")
+	expectRender(false)
+
+	// A burst of writes renders once
+	for _, contents := range []string{"TWO", "THREE", "FOUR"} {
+		writeTestFile(t, dir, "app.env.tmpl", contents)
+	}
+	expectRender(true)
+	expectRender(false)
+
+	// Editors that save by renaming a new file over the old one
+	tmp := writeTestFile(t, dir, ".app.env.tmpl.swp", "FIVE")
+	if err := os.Rename(tmp, src); err != nil {
+		t.Fatal(err)
+	}
+	expectRender(true)
+}
+
+func TestWatcherReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := writeTestFile(t, dir, "app.env.tmpl", "")
+	cache := newCachingVault(mockVaultClient{value: "secret"})
+	reload := make(chan os.Signal, 1)
+	renders := make(chan int, 10)
+	w := &Watcher{
+		Inputs:   func() ([]string, error) { return []string{src}, nil },
+		Debounce: time.Hour,
+		Render: func() error {
+			renders <- len(cache.values)
+			cache.GetStringValue("secret/app")
+			return nil
+		},
+		Refresher: &Refresher{Vault: cache},
+		Reload:    reload,
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() { errs <- w.Run(stop) }()
+	defer func() {
+		close(stop)
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-renders
+
+	// A reload renders immediately, without waiting for the debounce, and with an empty cache
+	reload <- syscall.SIGHUP
+	select {
+	case cached := <-renders:
+		if cached != 0 {
+			t.Fatalf("Expected the cache to be cleared but it held %v values", cached)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a render")
+	}
+}
+
+func TestIsInput(t *testing.T) {
+	inputs := map[string]bool{"/etc/app.env.tmpl": true, "/etc/partials": true, "/etc/shared/*.tmpl": true}
+
+	for _, name := range []string{"/etc/app.env.tmpl", "/etc/partials/_header.tmpl", "/etc/shared/footer.tmpl"} {
+		if !isInput(inputs, name) {
+			t.Fatalf("Expected %v to be an input", name)
+		}
+	}
+	for _, name := range []string{"/etc/app.env", "/etc/.app.env.tmpl.swp", "/etc/shared/footer.txt"} {
+		if isInput(inputs, name) {
+			t.Fatalf("Expected %v not to be an input", name)
+		}
+	}
+}
+
+func TestWatcherSurvivesRenderErrors(t *testing.T) {
+	context := newTestContext("", "", &bytes.Buffer{})
+	setupTest(context)
+	vault = newCachingVault(rotatingVaultClient{values: map[string]string{}})
+
+	dir, err := ioutil.TempDir("", "polymerase_test_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := writeTestFile(t, dir, "app.env.tmpl", "NAME={{ vault \"secret/missing\" }}\n")
+	envFile := writeTestFile(t, dir, "app.env", "NAME=\"unterminated")
+	config.EnvFiles = []string{envFile}
+	pairs := []TemplatePair{{Source: src, Destination: filepath.Join(dir, "app.conf")}}
+
+	results := make(chan error, 10)
+	w := &Watcher{
+		Inputs:   func() ([]string, error) { return watchInputs(pairs) },
+		Debounce: 50 * time.Millisecond,
+		Render: func() error {
+			_, err := renderChanged(pairs)
+			results <- err
+			return err
+		},
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() { errs <- w.Run(stop) }()
+	defer func() {
+		close(stop)
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	expectResult := func(expected string) {
+		select {
+		case err := <-results:
+			if (err == nil) != (len(expected) == 0) || (err != nil && !strings.Contains(err.Error(), expected)) {
+				t.Fatalf("Expected render error %q but got %v", expected, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected a render")
+		}
+	}
+
+	// A broken env file fails the render without stopping the watcher
+	expectResult("Error loading env file")
+
+	// So does a vault error once the env file is fixed
+	writeTestFile(t, dir, "app.env", "NAME=polymerase")
+	expectResult("Error fetching value from vault")
+
+	writeTestFile(t, dir, "app.env.tmpl", "NAME={{ .NAME }}\n")
+	expectResult("")
+	validateFile(pairs[0].Destination, "NAME=polymerase\n", t)
+}
+
+func TestWatcherStopError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polymerase_test_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := writeTestFile(t, dir, "app.env.tmpl", "")
+	w := &Watcher{
+		Inputs: func() ([]string, error) { return []string{src}, nil },
+		Render: func() error { return StopError{fmt.Errorf("hook aborted")} },
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- w.Run(make(chan struct{})) }()
+	select {
+	case err := <-errs:
+		if err == nil || err.Error() != "hook aborted" {
+			t.Fatalf("Expected the stop error but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a StopError to stop the watcher")
+	}
+}